@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
+	"github.com/DanArmor/GoTorrent/pkg/rpc"
+	"github.com/DanArmor/GoTorrent/pkg/session"
+	"github.com/DanArmor/GoTorrent/pkg/transmission"
+)
+
+// runDaemon runs the session without a terminal until it is interrupted,
+// serving the control API on a Unix socket and optionally on TCP.
+func runDaemon(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	configPath := configFlags(fs)
+	fs.String("socket", "", "path of the Unix socket of the API (default daemon.sock in the config directory)")
+	fs.String("listen", "", "TCP address to serve the API on as well, requires -token")
+	fs.String("token", "", "token clients must send on TCP")
+	fs.Bool("transmission", false, "serve the Transmission RPC protocol as well")
+	fs.Parse(args)
+	cfg, err := loadConfig(fs, *configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if cfg.Daemon.Listen != "" && cfg.Daemon.Token == "" {
+		fmt.Fprintln(os.Stderr, "a token is required to serve the API on TCP")
+		return 2
+	}
+	cfg.LogWriter = os.Stderr
+	s, err := session.New(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", rpc.NewServer(s).Handler())
+	if cfg.Daemon.Transmission {
+		mux.Handle(transmission.Path, transmission.NewServer(s))
+	}
+	servers, err := listenAPI(cfg, mux)
+	if err != nil {
+		s.Logger().Error("can't serve the API", "err", err)
+		stop()
+	}
+	<-ctx.Done()
+	for _, srv := range servers {
+		srv.Close()
+	}
+	if rerr := <-done; rerr != nil {
+		fmt.Fprintln(os.Stderr, rerr)
+		return 1
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// listenAPI serves handler on the Unix socket and, when configured, on TCP
+// behind the token.
+func listenAPI(cfg session.Config, handler http.Handler) ([]*http.Server, error) {
+	path := cfg.SocketPath()
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+	// The umask may not apply to sockets on every platform.
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	servers := []*http.Server{serve(ln, handler)}
+	if cfg.Daemon.Listen != "" {
+		ln, err := net.Listen("tcp", cfg.Daemon.Listen)
+		if err != nil {
+			servers[0].Close()
+			return nil, err
+		}
+		servers = append(servers, serve(ln, rpc.RequireToken(cfg.Daemon.Token, handler)))
+	}
+	if cfg.Daemon.Metrics != "" {
+		srv, err := listenMetrics(cfg.Daemon.Metrics)
+		if err != nil {
+			for _, srv := range servers {
+				srv.Close()
+			}
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	return servers, nil
+}
+
+// listenMetrics serves the Prometheus metrics at /metrics on addr.
+func listenMetrics(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	return serve(ln, mux), nil
+}
+
+func serve(ln net.Listener, handler http.Handler) *http.Server {
+	srv := &http.Server{Handler: handler}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+	return srv
+}
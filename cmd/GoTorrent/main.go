@@ -1,32 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"net"
-	"net/http"
 	"os"
 	"path/filepath"
-	"sync"
-	"time"
+	"strconv"
 
-	"github.com/DanArmor/GoTorrent/pkg/bitfield"
-	"github.com/DanArmor/GoTorrent/pkg/client"
-	"github.com/DanArmor/GoTorrent/pkg/handshake"
-	"github.com/DanArmor/GoTorrent/pkg/message"
-	"github.com/DanArmor/GoTorrent/pkg/p2p"
-	"github.com/DanArmor/GoTorrent/pkg/torrent"
+	"github.com/DanArmor/GoTorrent/pkg/session"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
 	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
-	"github.com/DanArmor/GoTorrent/pkg/utils"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-var SeedPeerID [utils.PeerIDLen]byte
-
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -43,340 +31,175 @@ func max(a, b int) int {
 	}
 }
 
-type Settings struct {
-	ConfigPath   string
-	DownloadPath string
-	Torrents     []torrentmeta.TorrentFile
-	Ctx          []context.Context
-	Wg           sync.WaitGroup
-}
-
-var GlobalSettings Settings
-
-func createAllParentDirs(p string) (*os.File, error) {
-	if err := os.MkdirAll(filepath.Dir(p), 0770); err != nil {
-		return nil, err
-	}
-	return os.Create(p)
-}
-
-func (s *Settings) makeMetaName(name string) string {
-	return filepath.Join(s.ConfigPath, name+"_meta.meta")
-}
-
-func (s *Settings) AddTorent(path string) {
-	tf := torrentmeta.New(path, GlobalSettings.DownloadPath)
-
-	allFilesExist := true
-	for i := range tf.Files {
-		if fi, err := os.Stat(tf.Files[i].FullPath); errors.Is(err, os.ErrNotExist) || fi.Size() != int64(tf.Files[i].Length) {
-			allFilesExist = false
-			break
-		}
-	}
-
-	if allFilesExist {
-		if tf.CheckFilesIntegrity() {
-			p2p.WriteToLog("All files exist")
-			tf.Bitfield = make(bitfield.Bitfield, len(tf.PieceHashes)/8+1)
-			for i := range tf.PieceHashes {
-				tf.Bitfield.SetPiece(i)
-			}
-			tf.IsDone = true
-			tf.Downloaded = len(tf.PieceHashes)
-		}
-	} else {
-		for i := range tf.Files {
-			f, err := createAllParentDirs(tf.Files[i].FullPath)
-			if err != nil {
-				panic(err)
-			}
-			if err := f.Truncate(int64(tf.Files[i].Length)); err != nil {
-				panic(err)
-			}
-			f.Close()
-		}
-	}
-	tf.Save(GlobalSettings.makeMetaName(tf.Name))
-	s.Torrents = append(s.Torrents, tf)
-	s.Ctx = append(s.Ctx, nil)
-}
+func formatBytes(size int) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	var i int
 
-func (s *Settings) LoadTorrents() {
-	entries, err := os.ReadDir(s.ConfigPath)
-	if err != nil {
-		panic(err)
-	}
-	for _, e := range entries {
-		var tf torrentmeta.TorrentFile
-		tf.Load(filepath.Join(s.ConfigPath, e.Name()))
-		s.Torrents = append(s.Torrents, tf)
-		s.Ctx = append(s.Ctx, nil)
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
 	}
-}
 
-func (s *Settings) RemoveTorrent(index int) {
-	metaName := s.makeMetaName(s.Torrents[index].Name)
-	s.stopTorrent(index)
-	s.Torrents = append(s.Torrents[:index], s.Torrents[index+1:]...)
-	s.Ctx = append(s.Ctx[:index], s.Ctx[index+1:]...)
-	os.Remove(metaName)
+	return fmt.Sprintf("%d %s", size, units[i])
 }
 
-func createDir(path string) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		err := os.Mkdir(path, 0777)
+// formatRate formats a rate in bytes per second.
+func formatRate(rate int) string {
+	return formatBytes(rate) + "/s"
+}
+
+// configFlags defines the flags overriding the configuration and returns
+// the one choosing its directory.
+func configFlags(fs *flag.FlagSet) *string {
+	configPath := fs.String("config", "", "directory of the configuration and torrent state")
+	fs.Bool("mmap", false, "memory-map downloaded files")
+	fs.String("alloc", storage.AllocateSparse.String(), "file allocation mode: sparse, full or none")
+	fs.String("download", "", "directory for downloads (default ~/Downloads)")
+	fs.String("incomplete", "", "directory for torrents that are not completed yet")
+	fs.String("completed", "", "directory completed torrents are moved to")
+	fs.String("log-level", "info", "log level: debug, info, warn or error")
+	fs.String("log-file", "", "file the log is written to as JSON")
+	fs.String("watch", "", "folder .torrent and .magnet files are added from")
+	fs.Int("max-downloads", 0, "most torrents downloading at once, 0 for no limit")
+	fs.Int("max-seeds", 0, "most torrents seeding at once, 0 for no limit")
+	fs.Int("max-active", 0, "most torrents running at once, 0 for no limit")
+	fs.Bool("ignore-slow", false, "do not count slow torrents toward the queue limits")
+	fs.Float64("seed-ratio", 0, "stop seeding at this share ratio, 0 for no limit")
+	fs.Int("seed-time", 0, "stop seeding after this many minutes, 0 for no limit")
+	fs.Int("seed-idle", 0, "stop seeding after this many minutes without uploads, 0 for no limit")
+	fs.String("seed-action", "", "what to do once seeding stops: pause, remove or remove_data")
+	fs.String("metrics", "", "TCP address to serve Prometheus metrics on at /metrics")
+	return configPath
+}
+
+// addWatchFolder adds dir to the watch folders unless it is one already.
+func addWatchFolder(folders []session.WatchFolder, dir string) []session.WatchFolder {
+	for _, f := range folders {
+		if filepath.Clean(f.Path) == filepath.Clean(dir) {
+			return folders
+		}
+	}
+	return append(folders, session.WatchFolder{Path: dir})
+}
+
+// loadConfig reads the configuration and applies the flags given on the
+// command line over it. A default config.json is written on the first run.
+func loadConfig(fs *flag.FlagSet, configPath string) (session.Config, error) {
+	if configPath == "" {
+		cfg, err := session.DefaultConfig()
 		if err != nil {
-			panic(err)
+			return session.Config{}, err
 		}
+		configPath = cfg.ConfigPath
 	}
+	cfg, err := session.LoadConfig(configPath)
+	if err != nil {
+		return session.Config{}, err
+	}
+	if _, err := os.Stat(filepath.Join(configPath, "config.json")); errors.Is(err, os.ErrNotExist) {
+		if err := cfg.Save(); err != nil {
+			return session.Config{}, err
+		}
+	}
+	// The first invalid flag is reported, the later ones are not applied.
+	var ferr error
+	fs.Visit(func(f *flag.Flag) {
+		if ferr != nil {
+			return
+		}
+		switch f.Name {
+		case "mmap":
+			cfg.Mmap = f.Value.String() == "true"
+		case "alloc":
+			cfg.Allocation, ferr = storage.ParseAllocation(f.Value.String())
+		case "download":
+			cfg.DownloadPath = f.Value.String()
+		case "incomplete":
+			cfg.IncompletePath = f.Value.String()
+		case "completed":
+			cfg.CompletedPath = f.Value.String()
+		case "log-level":
+			ferr = cfg.LogLevel.UnmarshalText([]byte(f.Value.String()))
+		case "log-file":
+			cfg.LogFile = f.Value.String()
+		case "watch":
+			cfg.Watch = addWatchFolder(cfg.Watch, f.Value.String())
+		case "max-downloads":
+			cfg.Queue.MaxDownloads, ferr = strconv.Atoi(f.Value.String())
+		case "max-seeds":
+			cfg.Queue.MaxSeeds, ferr = strconv.Atoi(f.Value.String())
+		case "max-active":
+			cfg.Queue.MaxActive, ferr = strconv.Atoi(f.Value.String())
+		case "ignore-slow":
+			cfg.Queue.IgnoreSlow = f.Value.String() == "true"
+		case "seed-ratio":
+			cfg.Seeding.Ratio, ferr = strconv.ParseFloat(f.Value.String(), 64)
+		case "seed-time":
+			cfg.Seeding.SeedTime, ferr = strconv.Atoi(f.Value.String())
+		case "seed-idle":
+			cfg.Seeding.IdleTime, ferr = strconv.Atoi(f.Value.String())
+		case "seed-action":
+			cfg.Seeding.Action, ferr = torrentmeta.ParseSeedAction(f.Value.String())
+		case "socket":
+			cfg.Daemon.Socket = f.Value.String()
+		case "listen":
+			cfg.Daemon.Listen = f.Value.String()
+		case "token":
+			cfg.Daemon.Token = f.Value.String()
+		case "metrics":
+			cfg.Daemon.Metrics = f.Value.String()
+		case "transmission":
+			cfg.Daemon.Transmission = f.Value.String() == "true"
+		}
+		if ferr != nil {
+			ferr = fmt.Errorf("invalid value %q for flag -%s: %w", f.Value.String(), f.Name, ferr)
+		}
+	})
+	return cfg, ferr
 }
 
-func (s *Settings) stopAllTorrents() {
-	for i := range s.Torrents {
-		s.stopTorrent(i)
-	}
-}
-
-func ReadBlock(pieceLength int, index int, begin int, length int, files []p2p.File) []byte {
-	totalOffset := pieceLength*index + begin
-	fileIndex := 0
-	for {
-		totalOffset = totalOffset - files[fileIndex].Length
-		if totalOffset <= 0 {
-			break
-		} else {
-			fileIndex++
-		}
-	}
-	n := 0
-	b := make([]byte, length)
-	files[fileIndex].Handler.Seek(int64(files[fileIndex].Length + totalOffset), io.SeekStart)
-	for {
-		r, err := files[fileIndex].Handler.Read(b)
-		if err != nil {
-			if err != io.EOF {
-				panic(err)
-			} else {
-				fileIndex++
-			}
-		}
-		n += r
-		if n == length {
-			break
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "daemon":
+			os.Exit(runDaemon(os.Args[2:]))
+		case "remote":
+			os.Exit(runRemote(os.Args[2:]))
 		}
 	}
-	return b
-}
-
-func (s *Settings) SeedTorrent(conn net.Conn) {
-	conn.SetDeadline(time.Now().Add(10 * time.Second))
-	res, err := handshake.Read(conn)
+	configPath := configFlags(flag.CommandLine)
+	flag.Parse()
+	cfg, err := loadConfig(flag.CommandLine, *configPath)
 	if err != nil {
-		return
-	}
-	serve := false
-	var infoHash [utils.InfoHashLen]byte
-	var bf bitfield.Bitfield
-	var files []torrent.File
-	var ctx context.Context
-	var pieceLength int
-	for i := range s.Torrents {
-		if bytes.Equal(res.InfoHash[:], s.Torrents[i].InfoHash[:]) && s.Torrents[i].IsDone && s.Torrents[i].InProgress {
-			serve = true
-			infoHash = s.Torrents[i].InfoHash
-			bf = s.Torrents[i].Bitfield
-			files = s.Torrents[i].Files
-			ctx = s.Ctx[i]
-			pieceLength = s.Torrents[i].PieceLength
-		}
+		fmt.Println(err)
+		os.Exit(2)
 	}
-	if serve {
-		conn.SetDeadline(time.Time{})
-		req := handshake.New(infoHash, SeedPeerID)
-		_, err := conn.Write(req.Serialize())
-		if err != nil {
-			panic(err)
-		}
-		defer conn.Close()
-		cl := &client.Client{
-			Conn:     conn,
-			Choked:   true,
-			InfoHash: infoHash,
-			PeerID:   SeedPeerID,
-		}
-		cl.SendBitfield(bf)
-		cl.SendUnchoke()
-
-		var p2pFiles []p2p.File
-		for i := range files {
-			f, err := os.Open(files[i].FullPath)
-			if err != nil {
-				panic(err)
-			}
-			p2pFiles = append(p2pFiles, p2p.File{File: files[i], Handler: f})
-			defer func(index int) {
-				p2pFiles[index].Handler.Close()
-			}(i)
-		}
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				m, err := cl.Read()
-				if err != nil {
-					panic(err)
-				}
-				switch m.ID {
-				case message.MsgUnchoke:
-					cl.Choked = false
-				case message.MsgChoke:
-					cl.Choked = true
-				case message.MsgRequest:
-					reqindex, reqbegin, reqlength, err := message.ParseRequest(m)
-					if err != nil {
-						panic(err)
-					}
-					if m.ID != message.MsgRequest {
-						continue
-					}
-					b := ReadBlock(pieceLength, reqindex, reqbegin, reqlength, p2pFiles)
-					cl.SendPiece(reqindex, reqbegin, b)
-				}
-			}
-		}
-	} else {
-		p2p.WriteToLog("Reject serve - wrong handshake")
-	}
-}
-
-func (s *Settings) Seeding(ctx context.Context) {
-	p2p.WriteToLog("Started seeding")
-	s.Wg.Add(1)
-	defer s.Wg.Done()
-	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", torrentmeta.Port))
+	s, err := session.New(cfg)
 	if err != nil {
-		p2p.WriteToLog("Error: " + fmt.Sprint(err))
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	var wg sync.WaitGroup
-	go func() {
-		for {
-			p2p.WriteToLog("Accepting")
-			conn, err := ln.Accept()
-			if err != nil {
-				p2p.WriteToLog("Error: " + fmt.Sprint(err))
-				break
-			}
-			p2p.WriteToLog("New connection")
-			wg.Add(1)
-			go func(c net.Conn) {
-				defer wg.Done()
-				p2p.WriteToLog("Start seed")
-				s.SeedTorrent(c)
-			}(conn)
-		}
-	}()
-	<-ctx.Done()
-	ln.Close()
-	wg.Wait()
-}
-
-func (s *Settings) startTorrent(index int) {
-	s.Wg.Add(1)
-	s.Torrents[index].InProgress = true
-	s.Torrents[index].Count = make(chan int)
-	s.Torrents[index].Done = make(chan struct{})
-	s.Torrents[index].Out = make(chan struct{})
-	if s.Torrents[index].IsDone {
-		trackerUrl, err := s.Torrents[index].BuildTrackerURL(SeedPeerID, torrentmeta.Port)
+	if cfg.Daemon.Metrics != "" {
+		srv, err := listenMetrics(cfg.Daemon.Metrics)
 		if err != nil {
-			panic(err)
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		c := &http.Client{Timeout: 15 * time.Second}
-		resp, err := c.Get(trackerUrl)
-		if err != nil {
-			p2p.WriteToLog("Can't announce")
-		}
-		resp.Body.Close()
-		go func() {
-			defer s.Wg.Done()
-			ctx, cancel := context.WithCancel(context.Background())
-			s.Ctx[index] = ctx
-			s.Torrents[index].InProgress = true
-			<-s.Torrents[index].Done
-			cancel()
-		}()
-	} else {
-		go func() {
-			defer s.Wg.Done()
-			s.Torrents[index].DownloadToFile()
-			if len(s.Torrents[index].PieceHashes) == s.Torrents[index].Downloaded {
-				s.Torrents[index].IsDone = true
-				s.Torrents[index].InProgress = false
-				s.Torrents[index].Save(s.makeMetaName(s.Torrents[index].Name))
-			}
-		}()
-	}
-}
-
-func (s *Settings) stopTorrent(index int) {
-	if s.Torrents[index].InProgress {
-		if s.Torrents[index].IsDone {
-			s.Torrents[index].Done <- struct{}{}
-		} else {
-			s.Torrents[index].Done <- struct{}{}
-			<-s.Torrents[index].Out
-		}
-		s.Torrents[index].InProgress = false
-		s.Torrents[index].Save(s.makeMetaName(s.Torrents[index].Name))
+		defer srv.Close()
 	}
-}
-
-func formatBytes(size int) string {
-	units := []string{"B", "KB", "MB", "GB", "TB"}
-	var i int
-
-	for size >= 1024 && i < len(units)-1 {
-		size /= 1024
-		i++
-	}
-
-	return fmt.Sprintf("%d %s", size, units[i])
-}
-
-func main() {
-	_, err := rand.Read(SeedPeerID[:])
-	if err != nil {
-		panic(err)
-	}
-	dir, err := os.UserConfigDir()
-	if err != nil {
-		panic(err)
-	}
-	GlobalSettings.ConfigPath = filepath.Join(dir, ".gotorrent")
-	createDir(GlobalSettings.ConfigPath)
-	dir, err = os.UserHomeDir()
-	if err != nil {
-		panic(err)
-	}
-	GlobalSettings.DownloadPath = filepath.Join(dir, "Downloads")
-
-	GlobalSettings.LoadTorrents()
 	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
 	go func() {
-		GlobalSettings.Seeding(ctx)
+		done <- s.Run(ctx)
 	}()
-	m := NewModel()
-	if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
-		cancel()
+	_, err = tea.NewProgram(NewModel(s), tea.WithAltScreen()).Run()
+	cancel()
+	if err != nil {
 		fmt.Println("Error during running program:", err)
 		fmt.Println("Wait for goroutines")
-		GlobalSettings.stopAllTorrents()
-		GlobalSettings.Wg.Wait()
+	}
+	<-done
+	if err != nil {
 		os.Exit(1)
 	}
-	cancel()
-	GlobalSettings.stopAllTorrents()
-	GlobalSettings.Wg.Wait()
 }
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DanArmor/GoTorrent/pkg/rpc"
+	"github.com/DanArmor/GoTorrent/pkg/session"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+const remoteUsage = `Usage: GoTorrent remote [flags] <command> [arguments]
+
+Commands:
+  add [-label category] <file.torrent|magnet>...
+  list
+  status <hash>
+  pause <hash>
+  resume <hash>
+  remove [-data] <hash>
+  priority <hash> <file> <skip|low|normal|high>
+  limits [<download> <upload>]
+  torrent-limits <hash> <download> <upload>
+  seed-limits [<ratio> <seed-minutes> <idle-minutes> [pause|remove|remove_data]]
+  torrent-seed-limits <hash> <ratio> <seed-minutes> <idle-minutes> [action]
+  queue <hash> <up|down|top|bottom>
+  queue-limits [<downloads> <seeds> <active>]
+  label <hash> [category]
+  categories
+  category [flags] <name>
+  category-remove <name>
+  peers <hash>
+  stats
+  events [type...]
+
+Limits are in bytes per second, 0 removes a limit. Seeding limits of 0
+remove a limit of the session; for a torrent 0 uses the limit of the
+session and -1 disables it. Queue limits of 0 remove a limit. The flags of
+category change only the given settings; see category -h.
+
+Flags:
+`
+
+var errUsage = errors.New("invalid arguments")
+
+// runRemote runs a command against a daemon.
+func runRemote(args []string) int {
+	fs := flag.NewFlagSet("remote", flag.ExitOnError)
+	configPath := fs.String("config", "", "directory of the configuration, used to find the socket")
+	socket := fs.String("socket", "", "path of the Unix socket of the daemon")
+	addr := fs.String("addr", "", "TCP address of the daemon")
+	token := fs.String("token", os.Getenv("GOTORRENT_TOKEN"), "token of the daemon on TCP (default $GOTORRENT_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), remoteUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var c *rpc.Client
+	if *addr != "" {
+		c = rpc.NewClient("tcp", *addr, *token)
+	} else {
+		if *socket == "" {
+			cfg, err := session.DefaultConfig()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			if *configPath != "" {
+				cfg.ConfigPath = *configPath
+			}
+			if cfg, err = session.LoadConfig(cfg.ConfigPath); err == nil {
+				*socket = cfg.SocketPath()
+			}
+		}
+		c = rpc.NewClient("unix", *socket, "")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := remoteCommand(ctx, c, fs.Arg(0), fs.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		return 2
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func remoteCommand(ctx context.Context, c *rpc.Client, cmd string, args []string) error {
+	switch cmd {
+	case "add":
+		label := ""
+		if len(args) > 1 && args[0] == "-label" {
+			label = args[1]
+			args = args[2:]
+		}
+		if len(args) == 0 {
+			return errUsage
+		}
+		for _, arg := range args {
+			var res rpc.AddResult
+			var err error
+			if strings.HasPrefix(arg, "magnet:") {
+				err = c.Call(ctx, rpc.MethodAddMagnet, rpc.AddMagnetParams{URI: arg, Label: label}, &res)
+			} else {
+				var metainfo []byte
+				if metainfo, err = os.ReadFile(arg); err == nil {
+					err = c.Call(ctx, rpc.MethodAdd, rpc.AddParams{Metainfo: metainfo, Label: label}, &res)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+			fmt.Println(res.InfoHash)
+		}
+		return nil
+	case "list":
+		var list []rpc.Torrent
+		if err := c.Call(ctx, rpc.MethodList, nil, &list); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HASH\tPOS\tSTATE\tDONE\tSIZE\tDOWN\tUP\tRATIO\tCATEGORY\tNAME")
+		for _, t := range list {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%.1f%%\t%s\t%s\t%s\t%.2f\t%s\t%s\n", t.InfoHash, t.QueuePosition, t.State, t.Progress*100, formatBytes(t.Size),
+				formatRate(t.DownloadRate), formatRate(t.UploadRate), t.Ratio, t.Label, t.Name)
+		}
+		return w.Flush()
+	case "status":
+		if len(args) != 1 {
+			return errUsage
+		}
+		var t rpc.Torrent
+		if err := c.Call(ctx, rpc.MethodStatus, rpc.HashParams{InfoHash: args[0]}, &t); err != nil {
+			return err
+		}
+		return printJSON(t)
+	case "pause", "resume":
+		if len(args) != 1 {
+			return errUsage
+		}
+		method := rpc.MethodPause
+		if cmd == "resume" {
+			method = rpc.MethodResume
+		}
+		return c.Call(ctx, method, rpc.HashParams{InfoHash: args[0]}, nil)
+	case "remove":
+		p := rpc.RemoveParams{}
+		if len(args) == 2 && args[0] == "-data" {
+			p.DeleteData = true
+			args = args[1:]
+		}
+		if len(args) != 1 {
+			return errUsage
+		}
+		p.InfoHash = args[0]
+		return c.Call(ctx, rpc.MethodRemove, p, nil)
+	case "priority":
+		if len(args) != 3 {
+			return errUsage
+		}
+		file, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errUsage
+		}
+		return c.Call(ctx, rpc.MethodSetPriority, rpc.PriorityParams{InfoHash: args[0], File: file, Priority: args[2]}, nil)
+	case "limits":
+		if len(args) == 0 {
+			var l rpc.Limits
+			if err := c.Call(ctx, rpc.MethodSessionLimits, nil, &l); err != nil {
+				return err
+			}
+			return printJSON(l)
+		}
+		l, err := parseLimits(args)
+		if err != nil {
+			return err
+		}
+		return c.Call(ctx, rpc.MethodSessionSetLimits, l, nil)
+	case "torrent-limits":
+		if len(args) != 3 {
+			return errUsage
+		}
+		l, err := parseLimits(args[1:])
+		if err != nil {
+			return err
+		}
+		l.InfoHash = args[0]
+		return c.Call(ctx, rpc.MethodSetLimits, l, nil)
+	case "seed-limits":
+		if len(args) == 0 {
+			var l rpc.SeedLimits
+			if err := c.Call(ctx, rpc.MethodSessionSeedLimits, nil, &l); err != nil {
+				return err
+			}
+			return printJSON(l)
+		}
+		l, err := parseSeedLimits(args)
+		if err != nil {
+			return err
+		}
+		return c.Call(ctx, rpc.MethodSessionSetSeedLimits, l, nil)
+	case "torrent-seed-limits":
+		if len(args) < 1 {
+			return errUsage
+		}
+		l, err := parseSeedLimits(args[1:])
+		if err != nil {
+			return err
+		}
+		l.InfoHash = args[0]
+		return c.Call(ctx, rpc.MethodSetSeedLimits, l, nil)
+	case "queue":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return c.Call(ctx, rpc.MethodQueueMove, rpc.QueueMoveParams{InfoHash: args[0], Move: args[1]}, nil)
+	case "queue-limits":
+		var q session.QueueConfig
+		if err := c.Call(ctx, rpc.MethodSessionQueue, nil, &q); err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return printJSON(q)
+		}
+		if len(args) != 3 {
+			return errUsage
+		}
+		for i, limit := range []*int{&q.MaxDownloads, &q.MaxSeeds, &q.MaxActive} {
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return errUsage
+			}
+			*limit = n
+		}
+		return c.Call(ctx, rpc.MethodSessionSetQueue, q, nil)
+	case "label":
+		if len(args) != 1 && len(args) != 2 {
+			return errUsage
+		}
+		p := rpc.LabelParams{InfoHash: args[0]}
+		if len(args) == 2 {
+			p.Label = args[1]
+		}
+		return c.Call(ctx, rpc.MethodSetLabel, p, nil)
+	case "categories":
+		var cats map[string]session.Category
+		if err := c.Call(ctx, rpc.MethodCategories, nil, &cats); err != nil {
+			return err
+		}
+		return printJSON(cats)
+	case "category":
+		return setCategory(ctx, c, args)
+	case "category-remove":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.Call(ctx, rpc.MethodRemoveCategory, rpc.CategoryParams{Name: args[0]}, nil)
+	case "peers":
+		if len(args) != 1 {
+			return errUsage
+		}
+		var peers []rpc.Peer
+		if err := c.Call(ctx, rpc.MethodPeers, rpc.HashParams{InfoHash: args[0]}, &peers); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PEER\tDOWN\tUP\tDOWNLOADED\tUPLOADED")
+		for _, p := range peers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Addr, formatRate(p.DownloadRate), formatRate(p.UploadRate),
+				formatBytes(int(p.Downloaded)), formatBytes(int(p.Uploaded)))
+		}
+		return w.Flush()
+	case "stats":
+		var st rpc.SessionStats
+		if err := c.Call(ctx, rpc.MethodSessionStats, nil, &st); err != nil {
+			return err
+		}
+		return printJSON(st)
+	case "events":
+		enc := json.NewEncoder(os.Stdout)
+		return c.Events(ctx, args, func(e rpc.Event) error {
+			return enc.Encode(e)
+		})
+	}
+	return errUsage
+}
+
+func parseLimits(args []string) (rpc.Limits, error) {
+	if len(args) != 2 {
+		return rpc.Limits{}, errUsage
+	}
+	down, err := strconv.Atoi(args[0])
+	if err != nil {
+		return rpc.Limits{}, errUsage
+	}
+	up, err := strconv.Atoi(args[1])
+	if err != nil {
+		return rpc.Limits{}, errUsage
+	}
+	return rpc.Limits{Download: down, Upload: up}, nil
+}
+
+// setCategory creates a category or changes the settings of one given as
+// flags.
+func setCategory(ctx context.Context, c *rpc.Client, args []string) error {
+	fs := flag.NewFlagSet("category", flag.ContinueOnError)
+	save := fs.String("save", "", "save path of the torrents")
+	down := fs.Int("down", 0, "download limit of all torrents together in bytes per second")
+	up := fs.Int("up", 0, "upload limit of all torrents together in bytes per second")
+	ratio := fs.Float64("ratio", 0, "seeding ratio limit")
+	seedTime := fs.Int("seed-time", 0, "seeding time limit in minutes")
+	idle := fs.Int("idle", 0, "seeding idle limit in minutes")
+	action := fs.String("action", "", "action once seeding stops: pause, remove or remove_data")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	var cats map[string]session.Category
+	if err := c.Call(ctx, rpc.MethodCategories, nil, &cats); err != nil {
+		return err
+	}
+	p := rpc.CategoryParams{Name: fs.Arg(0), Category: cats[fs.Arg(0)]}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "save":
+			p.SavePath = *save
+		case "down":
+			p.DownloadLimit = *down
+		case "up":
+			p.UploadLimit = *up
+		case "ratio":
+			p.Seeding.Ratio = *ratio
+		case "seed-time":
+			p.Seeding.SeedTime = *seedTime
+		case "idle":
+			p.Seeding.IdleTime = *idle
+		case "action":
+			p.Seeding.Action, err = torrentmeta.ParseSeedAction(*action)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return c.Call(ctx, rpc.MethodSetCategory, p, nil)
+}
+
+func parseSeedLimits(args []string) (rpc.SeedLimits, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return rpc.SeedLimits{}, errUsage
+	}
+	var l rpc.SeedLimits
+	var err error
+	if l.Ratio, err = strconv.ParseFloat(args[0], 64); err != nil {
+		return rpc.SeedLimits{}, errUsage
+	}
+	if l.SeedTime, err = strconv.Atoi(args[1]); err != nil {
+		return rpc.SeedLimits{}, errUsage
+	}
+	if l.IdleTime, err = strconv.Atoi(args[2]); err != nil {
+		return rpc.SeedLimits{}, errUsage
+	}
+	if len(args) == 4 {
+		if l.Action, err = torrentmeta.ParseSeedAction(args[3]); err != nil {
+			return rpc.SeedLimits{}, err
+		}
+	}
+	return l, nil
+}
+
+func printJSON(v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on the Unix socket at path, which is created readable
+// by the owner only.
+func listenUnix(path string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/DanArmor/GoTorrent/pkg/p2p"
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/session"
 	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -25,10 +30,18 @@ type keyMap struct {
 	Quit        key.Binding
 	StartStop   key.Binding
 	Remove      key.Binding
+	Recheck     key.Binding
+	Move        key.Binding
+	AddMagnet   key.Binding
+	LogLevel    key.Binding
+	QueueUp     key.Binding
+	QueueDown   key.Binding
+	Label       key.Binding
+	Filter      key.Binding
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Up, k.Down, k.StartStop, k.Remove, k.ViewTorrent, k.Quit}
+	return []key.Binding{k.Up, k.Down, k.StartStop, k.Remove, k.Recheck, k.Move, k.AddMagnet, k.LogLevel, k.QueueUp, k.QueueDown, k.Label, k.Filter, k.ViewTorrent, k.Quit}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
@@ -63,15 +76,109 @@ var keys = keyMap{
 		key.WithKeys("r"),
 		key.WithHelp("r", "remove torrent"),
 	),
+	Recheck: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "force recheck/cancel"),
+	),
+	Move: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "move storage"),
+	),
+	AddMagnet: key.NewBinding(
+		key.WithKeys("a"),
+		key.WithHelp("a", "add magnet"),
+	),
+	LogLevel: key.NewBinding(
+		key.WithKeys("l"),
+		key.WithHelp("l", "log level"),
+	),
+	QueueUp: key.NewBinding(
+		key.WithKeys("K"),
+		key.WithHelp("K", "queue up"),
+	),
+	QueueDown: key.NewBinding(
+		key.WithKeys("J"),
+		key.WithHelp("J", "queue down"),
+	),
+	Label: key.NewBinding(
+		key.WithKeys("L"),
+		key.WithHelp("L", "set category"),
+	),
+	Filter: key.NewBinding(
+		key.WithKeys("f"),
+		key.WithHelp("f", "filter category"),
+	),
+}
+
+type torrentKeyMap struct {
+	Up         key.Binding
+	Down       key.Binding
+	Raise      key.Binding
+	Lower      key.Binding
+	Skip       key.Binding
+	Rename     key.Binding
+	RenameRoot key.Binding
+	Back       key.Binding
+}
+
+func (k torrentKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.Raise, k.Lower, k.Skip, k.Rename, k.RenameRoot, k.Back}
+}
+
+func (k torrentKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down},
+		{k.Raise, k.Lower, k.Skip},
+		{k.Rename, k.RenameRoot},
+		{k.Back},
+	}
+}
+
+var torrentKeys = torrentKeyMap{
+	Up: key.NewBinding(
+		key.WithKeys("up", "k"),
+		key.WithHelp("↑/k", "previous file"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("down", "j"),
+		key.WithHelp("↓/j", "next file"),
+	),
+	Raise: key.NewBinding(
+		key.WithKeys("+", "="),
+		key.WithHelp("+", "raise priority"),
+	),
+	Lower: key.NewBinding(
+		key.WithKeys("-"),
+		key.WithHelp("-", "lower priority"),
+	),
+	Skip: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "skip/download file"),
+	),
+	Rename: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "rename file"),
+	),
+	RenameRoot: key.NewBinding(
+		key.WithKeys("N"),
+		key.WithHelp("N", "rename folder"),
+	),
+	Back: key.NewBinding(
+		key.WithKeys("q", "esc"),
+		key.WithHelp("esc/q", "back"),
+	),
 }
 
 const (
 	mainScreen = iota
 	filePickScreen
 	torrentViewScreen
+	inputScreen
 )
 
 type model struct {
+	s            *session.Session
+	sub          *event.Subscription
 	Width        int
 	Height       int
 	keys         keyMap
@@ -82,35 +189,52 @@ type model struct {
 	mv           viewport.Model
 	activeScreen int
 	fileNotInit  bool
+	fileCursor   int
+	input        textinput.Model
+	inputTitle   string
+	inputAction  func(string)
+	inputReturn  int
+	// filter shows only the torrents of a category when not empty.
+	filter string
 }
 
-func CreateTable() table.Model {
-	columns := []table.Column{
-		{Title: "№", Width: 4},
-		{Title: "Name", Width: 32},
-		{Title: "Size", Width: 16},
-		{Title: "Status", Width: 16},
-		{Title: "Progress", Width: 16},
+func statusText(st session.Status) string {
+	if st.State == session.StateChecking {
+		return fmt.Sprintf("Checking %.0f%%", 100.0*st.Checked)
 	}
+	return string(st.State)
+}
 
+func tableRows(s *session.Session, filter string) []table.Row {
 	var rows []table.Row
-	for i := range GlobalSettings.Torrents {
-		status := ""
-		if GlobalSettings.Torrents[i].InProgress {
-			if GlobalSettings.Torrents[i].IsDone {
-				status = "Uploading"
-			} else {
-				status = "Downloading"
-			}
-		} else {
-			status = "Stopped"
+	for _, st := range s.List() {
+		if filter != "" && st.Label != filter {
+			continue
 		}
 		rows = append(rows, table.Row{
-			strconv.Itoa(i + 1), GlobalSettings.Torrents[i].Name, formatBytes(GlobalSettings.Torrents[i].TotalSize),
-			status,
-			fmt.Sprintf("%.2f%%", 100.0 * float64(GlobalSettings.Torrents[i].Downloaded) / float64(len(GlobalSettings.Torrents[i].PieceHashes))),
+			strconv.Itoa(len(rows) + 1), st.Name, st.Label, formatBytes(st.Size),
+			statusText(st),
+			fmt.Sprintf("%.2f%%", 100.0*st.Progress),
+			formatRate(st.DownloadRate),
+			formatRate(st.UploadRate),
+			fmt.Sprintf("%.2f", st.Ratio),
 		})
 	}
+	return rows
+}
+
+func CreateTable(rows []table.Row) table.Model {
+	columns := []table.Column{
+		{Title: "№", Width: 4},
+		{Title: "Name", Width: 32},
+		{Title: "Category", Width: 12},
+		{Title: "Size", Width: 10},
+		{Title: "Status", Width: 16},
+		{Title: "Progress", Width: 10},
+		{Title: "Down", Width: 12},
+		{Title: "Up", Width: 12},
+		{Title: "Ratio", Width: 6},
+	}
 
 	t := table.New(
 		table.WithColumns(columns),
@@ -133,32 +257,74 @@ func CreateTable() table.Model {
 }
 
 func (m *model) RedrawRows() {
-	var rows []table.Row
-	for i := range GlobalSettings.Torrents {
-		status := ""
-		if GlobalSettings.Torrents[i].InProgress {
-			if GlobalSettings.Torrents[i].IsDone {
-				status = "Uploading"
-			} else {
-				status = "Downloading"
-			}
-		} else {
-			status = "Stopped"
+	m.t.SetRows(tableRows(m.s, m.filter))
+}
+
+// selected returns the torrent under the cursor, or nil when there are none.
+func (m *model) selected() *torrentmeta.TorrentFile {
+	var list []*torrentmeta.TorrentFile
+	for _, tf := range m.s.Torrents() {
+		if m.filter == "" || tf.Label == m.filter {
+			list = append(list, tf)
 		}
-		rows = append(rows, table.Row{
-			strconv.Itoa(i + 1), GlobalSettings.Torrents[i].Name, formatBytes(GlobalSettings.Torrents[i].TotalSize),
-			status,
-			fmt.Sprintf("%.2f%%", 100.0 * float64(GlobalSettings.Torrents[i].Downloaded) / float64(len(GlobalSettings.Torrents[i].PieceHashes))),
-		})
 	}
-	m.t.SetRows(rows)
+	if m.t.Cursor() < 0 || m.t.Cursor() >= len(list) {
+		return nil
+	}
+	return list[m.t.Cursor()]
 }
 
-func NewModel() model {
+func (m *model) report(err error) {
+	if err != nil {
+		m.s.Logger().Error(err.Error())
+	}
+}
+
+// cycleFilter shows the torrents of the next category, or all of them after
+// the last one.
+func (m *model) cycleFilter() {
+	var labels []string
+	for name := range m.s.Categories() {
+		labels = append(labels, name)
+	}
+	for _, tf := range m.s.Torrents() {
+		if tf.Label != "" {
+			labels = append(labels, tf.Label)
+		}
+	}
+	slices.Sort(labels)
+	labels = slices.Compact(labels)
+	i := slices.Index(labels, m.filter)
+	if i+1 < len(labels) {
+		m.filter = labels[i+1]
+	} else {
+		m.filter = ""
+	}
+	m.t.SetCursor(0)
+	m.RedrawRows()
+}
+
+var logLevels = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+// cycleLogLevel switches the session to the next log level.
+func (m *model) cycleLogLevel() {
+	level := logLevels[0]
+	for i, l := range logLevels {
+		if l == m.s.LogLevel() && i+1 < len(logLevels) {
+			level = logLevels[i+1]
+		}
+	}
+	m.s.SetLogLevel(level)
+	m.s.Logger().Log(context.Background(), level, "log level changed", "level", level)
+}
+
+func NewModel(s *session.Session) model {
 	return model{
+		s:    s,
+		sub:  s.Subscribe(0),
 		keys: keys,
 		help: help.New(),
-		t:    CreateTable(),
+		t:    CreateTable(tableRows(s, "")),
 		f: filetree.New(
 			true,
 			true,
@@ -175,22 +341,39 @@ func NewModel() model {
 	}
 }
 
-type tickMsg time.Time
+const magnetTimeout = 2 * time.Minute
+
+// refreshInterval is how often the table is redrawn to show the rates.
+const refreshInterval = time.Second
 
-func tickCmd() tea.Cmd {
-	return tea.Tick(500*time.Millisecond, func(t time.Time) tea.Msg {
-		return tickMsg(t)
+type refreshMsg struct{}
+
+func refresh() tea.Cmd {
+	return tea.Tick(refreshInterval, func(time.Time) tea.Msg {
+		return refreshMsg{}
 	})
 }
 
+// eventMsg carries the session events received since the last one. Events
+// that queued up meanwhile are taken at once, so a burst redraws only once.
+type eventMsg []event.Event
+
+func waitEvent(sub *event.Subscription) tea.Cmd {
+	return func() tea.Msg {
+		e, ok := <-sub.C
+		if !ok {
+			return nil
+		}
+		events := eventMsg{e}
+		for len(sub.C) > 0 {
+			events = append(events, <-sub.C)
+		}
+		return events
+	}
+}
+
 func (m model) Init() tea.Cmd {
-	var (
-		cmd  tea.Cmd
-		cmds []tea.Cmd
-	)
-	cmd = tickCmd()
-	cmds = append(cmds, m.f.Init(), cmd)
-	return tea.Batch(cmds...)
+	return tea.Batch(m.f.Init(), waitEvent(m.sub), refresh())
 }
 
 func (m model) UpdateTree(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -208,7 +391,9 @@ func (m model) UpdateTree(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.activeScreen = mainScreen
 		case "enter":
 			file := m.f.GetSelectedItem()
-			GlobalSettings.AddTorent(file.FileName())
+			if _, err := m.s.AddTorrent(file.FileName(), session.AddOptions{}); err != nil {
+				m.s.Logger().Error("can't add torrent", "file", file.FileName(), "err", err)
+			}
 		}
 	}
 
@@ -244,18 +429,53 @@ func (m model) UpdateMainScreen(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.String() {
 		case "ctrl+c", "q", "esc":
 			return m, tea.Quit
-		case "p":
-			if GlobalSettings.Torrents[m.t.Cursor()].InProgress {
-				GlobalSettings.stopTorrent(m.t.Cursor())
-			} else {
-				GlobalSettings.startTorrent(m.t.Cursor())
+		case "p", "r", "c", "m", "K", "J", "L", "enter":
+			tf := m.selected()
+			if tf == nil {
+				return m, nil
+			}
+			hash := tf.InfoHash
+			switch msg.String() {
+			case "p":
+				if tf.InProgress || tf.Queued {
+					m.report(m.s.Pause(hash))
+				} else {
+					m.report(m.s.Resume(hash))
+				}
+			case "r":
+				m.report(m.s.Remove(hash, false))
+			case "c":
+				m.report(m.s.Recheck(hash))
+			case "K":
+				m.report(m.s.MoveQueue(hash, session.QueueUp))
+				m.t.SetCursor(max(m.t.Cursor()-1, 0))
+			case "J":
+				m.report(m.s.MoveQueue(hash, session.QueueDown))
+				m.t.SetCursor(m.t.Cursor() + 1)
+			case "m":
+				return m, m.askInput("Move storage to:", tf.SavePath, func(dir string) {
+					m.report(m.s.Move(hash, dir))
+				})
+			case "L":
+				return m, m.askInput("Category:", tf.Label, func(label string) {
+					m.report(m.s.SetLabel(hash, label))
+				})
+			case "enter":
+				m.activeScreen = torrentViewScreen
+				m.fileCursor = 0
 			}
 			m.RedrawRows()
 			return m, nil
-		case "r":
-			GlobalSettings.RemoveTorrent(m.t.Cursor())
-			m.RedrawRows()
+		case "l":
+			m.cycleLogLevel()
 			return m, nil
+		case "f":
+			m.cycleFilter()
+			return m, nil
+		case "a":
+			return m, m.askInput("Magnet link:", "", func(uri string) {
+				m.addMagnet(uri)
+			})
 		case "o":
 			m.activeScreen = filePickScreen
 			if m.fileNotInit {
@@ -264,9 +484,6 @@ func (m model) UpdateMainScreen(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.fileNotInit = true
 				return m, m.f.Init()
 			}
-		case "enter":
-			m.activeScreen = torrentViewScreen
-			return m, nil
 		}
 	}
 
@@ -282,29 +499,124 @@ func (m model) UpdateMainScreen(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m model) UpdateTorrentView(msg tea.Msg) (tea.Model, tea.Cmd) {
+	tf := m.selected()
+	if tf == nil {
+		m.activeScreen = mainScreen
+		return m, nil
+	}
+	hash := tf.InfoHash
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.SetSize(msg)
+		m.Resize()
+	case tea.KeyMsg:
+		switch {
+		case msg.String() == "ctrl+c" || key.Matches(msg, torrentKeys.Back):
+			m.activeScreen = mainScreen
+			return m, nil
+		case key.Matches(msg, torrentKeys.Up):
+			m.fileCursor = max(m.fileCursor-1, 0)
+			return m, nil
+		case key.Matches(msg, torrentKeys.Down):
+			m.fileCursor = min(m.fileCursor+1, len(tf.Files)-1)
+			return m, nil
+		case key.Matches(msg, torrentKeys.Raise):
+			p := tf.FilePriority(m.fileCursor)
+			if p < torrentmeta.PriorityHigh {
+				m.report(m.s.SetFilePriority(hash, m.fileCursor, p+1))
+			}
+			return m, nil
+		case key.Matches(msg, torrentKeys.Lower):
+			p := tf.FilePriority(m.fileCursor)
+			if p > torrentmeta.PrioritySkip {
+				m.report(m.s.SetFilePriority(hash, m.fileCursor, p-1))
+			}
+			return m, nil
+		case key.Matches(msg, torrentKeys.Skip):
+			if tf.FilePriority(m.fileCursor) == torrentmeta.PrioritySkip {
+				m.report(m.s.SetFilePriority(hash, m.fileCursor, torrentmeta.PriorityNormal))
+			} else {
+				m.report(m.s.SetFilePriority(hash, m.fileCursor, torrentmeta.PrioritySkip))
+			}
+			return m, nil
+		case key.Matches(msg, torrentKeys.Rename):
+			file := m.fileCursor
+			return m, m.askInput("Rename file to:", tf.RelPath(file), func(name string) {
+				m.report(m.s.RenameFile(hash, file, name))
+			})
+		case key.Matches(msg, torrentKeys.RenameRoot):
+			if !tf.IsMultiple {
+				return m, nil
+			}
+			return m, m.askInput("Rename folder to:", tf.RootName(), func(name string) {
+				m.report(m.s.RenameRoot(hash, name))
+			})
+		}
+	}
 	newv, vcmd := m.v.Update(msg)
 	m.v = newv
+	return m, vcmd
+}
+
+// addMagnet fetches the metadata of a magnet link in the background.
+func (m model) addMagnet(uri string) {
+	if uri == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), magnetTimeout)
+		defer cancel()
+		if _, err := m.s.AddMagnet(ctx, uri, session.AddOptions{}); err != nil {
+			m.s.Logger().Error("can't add magnet link", "err", err)
+		}
+	}()
+}
+
+// askInput switches to the input screen. action is called with the entered
+// value once it is confirmed.
+func (m *model) askInput(title string, value string, action func(string)) tea.Cmd {
+	m.input = textinput.New()
+	m.input.SetValue(value)
+	m.input.Width = max(m.Width-len(title)-4, 20)
+	m.inputTitle = title
+	m.inputAction = action
+	m.inputReturn = m.activeScreen
+	m.activeScreen = inputScreen
+	return m.input.Focus()
+}
+
+func (m model) UpdateInput(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.SetSize(msg)
 		m.Resize()
 	case tea.KeyMsg:
 		switch msg.String() {
-		case "ctrl+c", "q", "esc":
-			m.activeScreen = mainScreen
+		case "ctrl+c", "esc":
+			m.activeScreen = m.inputReturn
+			return m, nil
+		case "enter":
+			m.activeScreen = m.inputReturn
+			m.inputAction(strings.TrimSpace(m.input.Value()))
+			m.RedrawRows()
 			return m, nil
 		}
 	}
-	return m, vcmd
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg.(type) {
-	case tickMsg:
+	case eventMsg:
 		m.RedrawRows()
-		m.mv.SetContent(p2p.GetLogString())
+		m.mv.SetContent(strings.Join(m.s.LogLines(), "\n"))
 		m.mv.GotoBottom()
-		return m, tickCmd()
+		return m, waitEvent(m.sub)
+	case refreshMsg:
+		m.RedrawRows()
+		return m, refresh()
 	}
 
 	switch m.activeScreen {
@@ -314,6 +626,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.UpdateTree(msg)
 	case torrentViewScreen:
 		return m.UpdateTorrentView(msg)
+	case inputScreen:
+		return m.UpdateInput(msg)
 	default:
 		panic("No such screen")
 	}
@@ -339,10 +653,17 @@ func (m model) filePickScreenView() string {
 
 func (m model) mainScreenView() string {
 	helpView := m.help.View(m.keys)
+	if m.filter != "" {
+		helpView = tts.Render("Category: "+m.filter) + "\n" + helpView
+	}
 	return baseStyle.Render(m.t.View()) + "\n" + viewStyle.Render(m.mv.View()) + "\n\n" + helpView
 }
 
-func (m model) advInfo(tf torrentmeta.TorrentFile) string {
+func (m model) inputScreenView() string {
+	return tts.Render(m.inputTitle) + "\n" + m.input.View() + "\n\nenter: confirm • esc: cancel"
+}
+
+func (m model) advInfo(tf *torrentmeta.TorrentFile) string {
 	var strs []string
 	if tf.CreatedBy != "" {
 		strs = append(strs, tcs.Render(fmt.Sprintf("%s %s", tts.Render("Created by:"), tf.CreatedBy)))
@@ -355,18 +676,59 @@ func (m model) advInfo(tf torrentmeta.TorrentFile) string {
 	)
 }
 
+func (m model) filesInfo(tf *torrentmeta.TorrentFile) string {
+	var strs []string
+	for i := range tf.Files {
+		cursor := "  "
+		if i == m.fileCursor {
+			cursor = "> "
+		}
+		kind := formatBytes(tf.Files[i].Length)
+		if tf.Files[i].IsPadding() {
+			kind = "padding"
+		} else if tf.Files[i].IsSymlink() {
+			kind = "link to " + strings.Join(tf.Files[i].SymlinkPath, "/")
+		}
+		strs = append(strs, fmt.Sprintf("%s[%-6s] %s (%s)", cursor, tf.FilePriority(i), tf.Files[i].FullPath, kind))
+	}
+	return tcs.Render(fmt.Sprintf("%s\n%s", tts.Render("Files:"), strings.Join(strs, "\n")))
+}
+
+func transferText(tf *torrentmeta.TorrentFile) string {
+	total := tf.Stats().Total()
+	down, up := tf.Stats().Rates()
+	return fmt.Sprintf("%s down (%s), %s up (%s), ratio %.2f, protocol %s down / %s up",
+		formatBytes(int(total.Downloaded)), formatRate(int(down)),
+		formatBytes(int(total.Uploaded)), formatRate(int(up)), tf.Ratio(),
+		formatBytes(int(total.ProtocolDownloaded)), formatBytes(int(total.ProtocolUploaded)))
+}
+
 func (m model) torrentViewScreenView() string {
-	tf := GlobalSettings.Torrents[m.t.Cursor()]
-	m.v.SetContent(strings.Join(
-		[]string{
-			tcs.Render(fmt.Sprintf("%s %s", tts.Render("Name:"), tf.Name)),
-			tcs.Render(fmt.Sprintf("%s %s", tts.Render("Tracker URL:"), tf.Announce)),
-			tcs.Render(fmt.Sprintf("%s %s", tts.Render("InfoHash:"), hex.EncodeToString(tf.InfoHash[:]))),
-			tcs.Render(fmt.Sprintf("%s %s", tts.Render("Amount of pieces:"), strconv.Itoa(len(tf.PieceHashes)))),
-			m.advInfo(tf),
-		}, "\n"))
-	m.v.GotoTop()
-	return m.v.View()
+	tf := m.selected()
+	if tf == nil {
+		return ""
+	}
+	info := []string{
+		tcs.Render(fmt.Sprintf("%s %s", tts.Render("Name:"), tf.Name)),
+		tcs.Render(fmt.Sprintf("%s %s", tts.Render("Tracker URL:"), tf.Announce)),
+		tcs.Render(fmt.Sprintf("%s %s", tts.Render("InfoHash:"), hex.EncodeToString(tf.InfoHash[:]))),
+		tcs.Render(fmt.Sprintf("%s %s", tts.Render("Save path:"), tf.SavePath)),
+		tcs.Render(fmt.Sprintf("%s %s", tts.Render("Amount of pieces:"), strconv.Itoa(len(tf.PieceHashes)))),
+		tcs.Render(fmt.Sprintf("%s %s", tts.Render("Transferred:"), transferText(tf))),
+	}
+	if goal, err := m.s.SeedGoal(tf.InfoHash); err == nil && tf.IsDone {
+		info = append(info, tcs.Render(fmt.Sprintf("%s %s (seeded %s)", tts.Render("Seeding goal:"), goal, tf.SeedTime.Round(time.Second))))
+	}
+	if tf.Error != "" {
+		info = append(info, tcs.Render(fmt.Sprintf("%s %s", tts.Render("Error:"), tf.Error)))
+	}
+	if adv := m.advInfo(tf); adv != "" {
+		info = append(info, adv)
+	}
+	header := strings.Join(info, "\n")
+	m.v.SetContent(header + "\n" + m.filesInfo(tf))
+	m.v.SetYOffset(max(0, lipgloss.Height(header)+2+m.fileCursor-m.v.Height+1))
+	return m.v.View() + "\n" + m.help.View(torrentKeys)
 }
 
 func (m model) View() string {
@@ -378,6 +740,8 @@ func (m model) View() string {
 		toRender = m.filePickScreenView()
 	case torrentViewScreen:
 		toRender = m.torrentViewScreenView()
+	case inputScreen:
+		toRender = m.inputScreenView()
 	}
 	return toRender
 }
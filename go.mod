@@ -1,6 +1,6 @@
 module github.com/DanArmor/GoTorrent
 
-go 1.20
+go 1.21
 
 require (
 	fyne.io/fyne/v2 v2.3.3 // indirect
@@ -19,3 +19,12 @@ func (bf Bitfield) SetPiece(index int) {
 	}
 	bf[byteIndex] |= 1 << uint(7-offset)
 }
+
+func (bf Bitfield) ClearPiece(index int) {
+	byteIndex := index / 8
+	offset := index % 8
+	if byteIndex < 0 || byteIndex >= len(bf) {
+		return
+	}
+	bf[byteIndex] &^= 1 << uint(7-offset)
+}
@@ -9,10 +9,18 @@ import (
 	"github.com/DanArmor/GoTorrent/pkg/bitfield"
 	"github.com/DanArmor/GoTorrent/pkg/handshake"
 	"github.com/DanArmor/GoTorrent/pkg/message"
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
 	"github.com/DanArmor/GoTorrent/pkg/peers"
+	"github.com/DanArmor/GoTorrent/pkg/stats"
 	"github.com/DanArmor/GoTorrent/pkg/utils"
 )
 
+var (
+	handshakeFailures = metrics.Default.Counter("gotorrent_peer_handshake_failures_total", "Connections to peers that failed before the bitfield was received.")
+	chokeState        = metrics.Default.GaugeVec("gotorrent_peer_choke_state", "Connected peers by whether they choke us.", "info_hash", "state")
+	uploadedBytes     = metrics.Default.CounterVec("gotorrent_uploaded_bytes_total", "Piece data sent to peers.", "info_hash")
+)
+
 type Client struct {
 	Conn     net.Conn
 	Choked   bool
@@ -20,6 +28,9 @@ type Client struct {
 	peer     peers.Peer
 	InfoHash [utils.InfoHashLen]byte
 	PeerID   [utils.PeerIDLen]byte
+	// Stats counts the piece data moved, when set.
+	Stats  *stats.Stats
+	closed bool
 }
 
 func CheckHandshake(peer peers.Peer, peerID [utils.PeerIDLen]byte, infoHash [utils.InfoHashLen]byte) error {
@@ -43,23 +54,66 @@ func New(peer peers.Peer, peerID [utils.PeerIDLen]byte, infoHash [utils.InfoHash
 
 	_, err = completeHandshake(conn, infoHash, peerID)
 	if err != nil {
+		handshakeFailures.Inc()
 		conn.Close()
 		return nil, err
 	}
 	bf, err := RecvBitfield(conn)
 	if err != nil {
+		handshakeFailures.Inc()
 		conn.Close()
 		return nil, err
 	}
 
-	return &Client{
+	c := &Client{
 		Conn:     conn,
 		Choked:   true,
 		Bitfield: bf,
 		peer:     peer,
 		InfoHash: infoHash,
 		PeerID:   peerID,
-	}, nil
+	}
+	c.chokeGauge().Inc()
+	return c, nil
+}
+
+// Accept wraps a connection a peer opened to us, after the handshake.
+func Accept(conn net.Conn, infoHash [utils.InfoHashLen]byte, peerID [utils.PeerIDLen]byte) *Client {
+	c := &Client{
+		Conn:     conn,
+		Choked:   true,
+		InfoHash: infoHash,
+		PeerID:   peerID,
+	}
+	c.chokeGauge().Inc()
+	return c
+}
+
+func (c *Client) chokeGauge() *metrics.Gauge {
+	state := "unchoked"
+	if c.Choked {
+		state = "choked"
+	}
+	return chokeState.With(metrics.InfoHash(c.InfoHash), state)
+}
+
+// SetChoked records whether the peer chokes us.
+func (c *Client) SetChoked(choked bool) {
+	if c.Choked == choked || c.closed {
+		c.Choked = choked
+		return
+	}
+	c.chokeGauge().Dec()
+	c.Choked = choked
+	c.chokeGauge().Inc()
+}
+
+func (c *Client) Close() error {
+	if !c.closed {
+		c.closed = true
+		c.chokeGauge().Dec()
+	}
+	return c.Conn.Close()
 }
 
 func completeHandshake(conn net.Conn, infoHash [utils.InfoHashLen]byte, peerID [utils.PeerIDLen]byte) (*handshake.Handshake, error) {
@@ -1,8 +1,11 @@
 package client
 
 import (
+	"net"
+
 	"github.com/DanArmor/GoTorrent/pkg/bitfield"
 	"github.com/DanArmor/GoTorrent/pkg/message"
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
 )
 
 func (c *Client) SendRequest(index int, begin int, length int) error {
@@ -36,8 +39,12 @@ func (c *Client) SendHave(index int) error {
 }
 
 func (c *Client) SendPiece(index int, begin int, b []byte) error{
-	m := message.FormatPiece(index, begin, b)
-	_, err := c.Conn.Write(m.Serialize())
+	bufs := net.Buffers{message.FormatPieceHeader(index, begin, len(b)), b}
+	_, err := bufs.WriteTo(c.Conn)
+	if err == nil {
+		uploadedBytes.With(metrics.InfoHash(c.InfoHash)).Add(len(b))
+		c.Stats.AddUploaded(len(b))
+	}
 	return err
 }
 
@@ -0,0 +1,86 @@
+package diskio
+
+import (
+	"container/list"
+	"sync"
+)
+
+type cacheKey struct {
+	disk  *Disk
+	index int
+}
+
+type cacheEntry struct {
+	key cacheKey
+	buf []byte
+}
+
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	maxSize int
+	order   *list.List
+	entries map[cacheKey]*list.Element
+}
+
+func newCache(maxSize int) *cache {
+	return &cache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *cache) get(d *Disk, index int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cacheKey{d, index}]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).buf, true
+}
+
+func (c *cache) put(d *Disk, index int, buf []byte) {
+	if len(buf) > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{d, index}
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, buf: buf})
+	c.size += len(buf)
+	for c.size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *cache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= len(entry.buf)
+}
+
+func (c *cache) drop(d *Disk, first int, last int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := first; i <= last; i++ {
+		if e, ok := c.entries[cacheKey{d, i}]; ok {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *cache) forget(d *Disk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if key.disk == d {
+			c.remove(e)
+		}
+	}
+}
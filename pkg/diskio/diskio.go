@@ -0,0 +1,253 @@
+package diskio
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/DanArmor/GoTorrent/pkg/storage"
+)
+
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 64
+	DefaultCacheSize = 32 << 20
+
+	maxCoalescedSize = 4 << 20
+)
+
+var ErrClosed = errors.New("disk is closed")
+
+type Config struct {
+	Workers   int `json:"workers,omitempty"`
+	QueueSize int `json:"queue_size,omitempty"`
+	CacheSize int `json:"cache_size,omitempty"`
+}
+
+type writeJob struct {
+	disk   *Disk
+	offset int
+	buf    []byte
+	done   []func(error)
+}
+
+func (j *writeJob) end() int {
+	return j.offset + len(j.buf)
+}
+
+// Pool runs disk writes of all torrents on a bounded number of workers and
+// keeps a read cache shared by the upload connections.
+type Pool struct {
+	cfg      Config
+	mu       sync.Mutex
+	hasWork  *sync.Cond
+	hasSpace *sync.Cond
+	queue    []*writeJob
+	closed   bool
+	cache    *cache
+	wg       sync.WaitGroup
+}
+
+func New(cfg Config) *Pool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = DefaultCacheSize
+	}
+	p := &Pool{
+		cfg:   cfg,
+		cache: newCache(cfg.CacheSize),
+	}
+	p.hasWork = sync.NewCond(&p.mu)
+	p.hasSpace = sync.NewCond(&p.mu)
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	return p
+}
+
+// QueueLen returns the number of writes waiting for a worker.
+func (p *Pool) QueueLen() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+func (p *Pool) enqueue(j *writeJob) {
+	p.mu.Lock()
+	for len(p.queue) >= p.cfg.QueueSize && !p.closed {
+		p.hasSpace.Wait()
+	}
+	if p.closed {
+		p.mu.Unlock()
+		for _, done := range j.done {
+			done(ErrClosed)
+		}
+		j.disk.pending.Done()
+		return
+	}
+	p.queue = append(p.queue, j)
+	p.mu.Unlock()
+	p.hasWork.Signal()
+}
+
+// next takes the first queued write and merges into it the queued writes of
+// the same disk that continue it.
+func (p *Pool) next() *writeJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.queue) == 0 && !p.closed {
+		p.hasWork.Wait()
+	}
+	if len(p.queue) == 0 {
+		return nil
+	}
+	j := p.queue[0]
+	p.queue = p.queue[1:]
+	merged := false
+	for found := true; found && len(j.buf) < maxCoalescedSize; {
+		found = false
+		for i, other := range p.queue {
+			if other.disk == j.disk && other.offset == j.end() {
+				if !merged {
+					j = &writeJob{disk: j.disk, offset: j.offset, buf: append([]byte(nil), j.buf...), done: j.done}
+					merged = true
+				}
+				j.buf = append(j.buf, other.buf...)
+				j.done = append(j.done, other.done...)
+				p.queue = append(p.queue[:i], p.queue[i+1:]...)
+				found = true
+				break
+			}
+		}
+	}
+	p.hasSpace.Broadcast()
+	return j
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for {
+		j := p.next()
+		if j == nil {
+			return
+		}
+		d := j.disk
+		index := j.offset / d.pieceLength
+		err := d.st.WritePiece(index, j.offset-index*d.pieceLength, j.buf)
+		p.cache.drop(d, index, (j.end()-1)/d.pieceLength)
+		for _, done := range j.done {
+			done(err)
+			d.pending.Done()
+		}
+	}
+}
+
+// Close finishes the queued writes and stops the workers.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.hasWork.Broadcast()
+	p.hasSpace.Broadcast()
+	p.wg.Wait()
+}
+
+// Disk is the storage of one torrent attached to a pool.
+type Disk struct {
+	pool        *Pool
+	st          storage.Storage
+	layout      storage.Layout
+	pieceLength int
+	pending     sync.WaitGroup
+	mu          sync.RWMutex
+	closed      bool
+}
+
+func (p *Pool) Open(st storage.Storage, layout storage.Layout) *Disk {
+	return &Disk{
+		pool:        p,
+		st:          st,
+		layout:      layout,
+		pieceLength: layout.PieceLength,
+	}
+}
+
+func (d *Disk) Storage() storage.Storage {
+	return d.st
+}
+
+// Write queues buf to be written at begin of the piece, blocking while the
+// queue of the pool is full. done is called from a worker once the data is
+// written and must not block.
+func (d *Disk) Write(index int, begin int, buf []byte, done func(error)) {
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		done(ErrClosed)
+		return
+	}
+	d.pending.Add(1)
+	d.mu.RUnlock()
+	d.pool.enqueue(&writeJob{
+		disk:   d,
+		offset: index*d.pieceLength + begin,
+		buf:    buf,
+		done:   []func(error){done},
+	})
+}
+
+// Wait blocks until all queued writes of the disk are done.
+func (d *Disk) Wait() {
+	d.pending.Wait()
+}
+
+// MaxBlockSize is the largest block peers may request.
+const MaxBlockSize = 16384
+
+// Serve passes the requested block to fn. The block is taken from the
+// storage without copying when it supports it, or from the read cache
+// otherwise, and must not be used after fn returns.
+func (d *Disk) Serve(index int, begin int, length int, fn func([]byte) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrClosed
+	}
+	if index < 0 || begin < 0 || length <= 0 || length > MaxBlockSize || begin+length > d.layout.PieceSize(index) {
+		return errors.New("requested block is out of the piece bounds")
+	}
+	if sr, ok := d.st.(storage.SliceReader); ok {
+		if b, ok := sr.PieceSlice(index, begin, length); ok {
+			return fn(b)
+		}
+	}
+	piece, ok := d.pool.cache.get(d, index)
+	if !ok {
+		piece = make([]byte, d.layout.PieceSize(index))
+		if err := d.st.ReadPiece(index, 0, piece); err != nil {
+			return err
+		}
+		d.pool.cache.put(d, index, piece)
+	}
+	return fn(piece[begin : begin+length])
+}
+
+// Close waits for the queued writes, drops the cached pieces and closes the
+// storage.
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+	d.pending.Wait()
+	d.pool.cache.forget(d)
+	return d.st.Close()
+}
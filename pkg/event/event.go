@@ -0,0 +1,114 @@
+package event
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+type Type string
+
+const (
+	TorrentAdded     Type = "torrent_added"
+	TorrentRemoved   Type = "torrent_removed"
+	TorrentStarted   Type = "torrent_started"
+	TorrentStopped   Type = "torrent_stopped"
+	TorrentFinished  Type = "torrent_finished"
+	TorrentError     Type = "torrent_error"
+	SeedGoalReached  Type = "seed_goal_reached"
+	RecheckProgress  Type = "recheck_progress"
+	RecheckFinished  Type = "recheck_finished"
+	MoveStarted      Type = "move_started"
+	MoveFinished     Type = "move_finished"
+	PieceVerified    Type = "piece_verified"
+	HashFailed       Type = "hash_failed"
+	TrackerAnnounced Type = "tracker_announced"
+	TrackerError     Type = "tracker_error"
+	PeerConnected    Type = "peer_connected"
+	PeerDisconnected Type = "peer_disconnected"
+	StorageError     Type = "storage_error"
+	LogMessage       Type = "log_message"
+)
+
+// Event is a single thing that happened in a session. Fields that do not
+// apply to the type are left empty.
+type Event struct {
+	Type     Type
+	Time     time.Time
+	InfoHash [utils.InfoHashLen]byte
+	Piece    int
+	Peer     string
+	Progress float64
+	Message  string
+}
+
+// DefaultBuffer is the channel size used when Subscribe is given zero.
+const DefaultBuffer = 256
+
+// Bus delivers published events to every subscriber. Publishing never
+// blocks: when a subscriber falls behind, events for it are dropped and
+// counted. A nil Bus drops everything.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	bus     *Bus
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		select {
+		case sub.c <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe returns a subscription buffering up to size events.
+func (b *Bus) Subscribe(size int) *Subscription {
+	if size <= 0 {
+		size = DefaultBuffer
+	}
+	c := make(chan Event, size)
+	sub := &Subscription{C: c, c: c, bus: b}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Dropped returns how many events did not fit in the channel.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the delivery and closes C.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.c)
+	})
+}
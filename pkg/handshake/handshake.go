@@ -9,17 +9,28 @@ import (
 
 type Handshake struct {
 	Pstr     string
+	Reserved [8]byte
 	InfoHash [utils.InfoHashLen]byte
 	PeerID   [utils.PeerIDLen]byte
 }
 
+// extensionBit is the reserved bit of the extension protocol (BEP 10).
+const extensionBit = 0x10
+
+func (h *Handshake) SetExtensions() {
+	h.Reserved[5] |= extensionBit
+}
+
+func (h *Handshake) SupportsExtensions() bool {
+	return h.Reserved[5]&extensionBit != 0
+}
+
 func (h *Handshake) Serialize() []byte {
-	var reserved [8]byte
 	buf := make([]byte, utils.HandshakeSize)
 	buf[0] = byte(len(h.Pstr))
 	curr := 1
 	curr += copy(buf[curr:], []byte(h.Pstr))
-	curr += copy(buf[curr:], reserved[:])
+	curr += copy(buf[curr:], h.Reserved[:])
 	curr += copy(buf[curr:], h.InfoHash[:])
 	curr += copy(buf[curr:], h.PeerID[:])
 	return buf
@@ -46,6 +57,7 @@ func Read(r io.Reader) (*Handshake, error) {
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
+	copy(h.Reserved[:], buf[1+utils.ProtocolIDLen:])
 
 	return &h, nil
 }
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+func Component(name string) slog.Attr {
+	return slog.String("component", name)
+}
+
+func InfoHash(hash [utils.InfoHashLen]byte) slog.Attr {
+	return slog.String("info_hash", hex.EncodeToString(hash[:]))
+}
+
+func Peer(addr string) slog.Attr {
+	return slog.String("peer", addr)
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+// Discard returns a logger that drops everything.
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+// OrDiscard returns l, or a discarding logger when l is nil.
+func OrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return Discard()
+	}
+	return l
+}
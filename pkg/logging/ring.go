@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// DefaultRingSize is the number of lines a Ring keeps when given zero.
+const DefaultRingSize = 1000
+
+type ringBuffer struct {
+	mu    sync.RWMutex
+	lines []string
+	next  int
+	full  bool
+	// notify is called with every line added.
+	notify func(slog.Level, string)
+}
+
+// Ring is a handler keeping the last lines logged in memory, formatted for
+// display.
+type Ring struct {
+	level  slog.Leveler
+	buf    *ringBuffer
+	prefix string
+	attrs  string
+}
+
+// NewRing returns a ring of size lines. notify, when not nil, is called for
+// every line added.
+func NewRing(size int, level slog.Leveler, notify func(slog.Level, string)) *Ring {
+	if size <= 0 {
+		size = DefaultRingSize
+	}
+	return &Ring{
+		level: level,
+		buf:   &ringBuffer{lines: make([]string, size), notify: notify},
+	}
+}
+
+func (r *Ring) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= r.level.Level()
+}
+
+func (r *Ring) Handle(_ context.Context, rec slog.Record) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %-5s %s", rec.Time.Format("15:04:05"), rec.Level, rec.Message)
+	sb.WriteString(r.attrs)
+	rec.Attrs(func(a slog.Attr) bool {
+		writeAttr(&sb, r.prefix, a)
+		return true
+	})
+	line := sb.String()
+
+	b := r.buf
+	b.mu.Lock()
+	b.lines[b.next] = line
+	b.next++
+	if b.next == len(b.lines) {
+		b.next = 0
+		b.full = true
+	}
+	b.mu.Unlock()
+	if b.notify != nil {
+		b.notify(rec.Level, line)
+	}
+	return nil
+}
+
+func writeAttr(sb *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		group := prefix
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			writeAttr(sb, group, ga)
+		}
+		return
+	}
+	fmt.Fprintf(sb, " %s%s=%v", prefix, a.Key, a.Value)
+}
+
+func (r *Ring) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	sb.WriteString(r.attrs)
+	for _, a := range attrs {
+		writeAttr(&sb, r.prefix, a)
+	}
+	nr := *r
+	nr.attrs = sb.String()
+	return &nr
+}
+
+func (r *Ring) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return r
+	}
+	nr := *r
+	nr.prefix += name + "."
+	return &nr
+}
+
+// Lines returns the kept lines, oldest first.
+func (r *Ring) Lines() []string {
+	b := r.buf
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	lines := make([]string, 0, len(b.lines))
+	lines = append(lines, b.lines[b.next:]...)
+	return append(lines, b.lines[:b.next]...)
+}
+
+func (r *Ring) String() string {
+	return strings.Join(r.Lines(), "\n")
+}
@@ -0,0 +1,70 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+var ErrNotMagnet = errors.New("not a magnet link")
+
+// Link is a parsed magnet link. Only BitTorrent v1 info hashes are supported.
+type Link struct {
+	InfoHash [utils.InfoHashLen]byte
+	Name     string
+	Trackers []string
+}
+
+func Parse(uri string) (*Link, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "magnet" {
+		return nil, ErrNotMagnet
+	}
+	q := u.Query()
+	link := &Link{
+		Name:     q.Get("dn"),
+		Trackers: q["tr"],
+	}
+	found := false
+	for _, xt := range q["xt"] {
+		hash, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+		if err := parseHash(hash, &link.InfoHash); err != nil {
+			return nil, err
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("magnet link has no BitTorrent info hash")
+	}
+	return link, nil
+}
+
+func parseHash(hash string, dst *[utils.InfoHashLen]byte) error {
+	var buf []byte
+	var err error
+	switch len(hash) {
+	case 2 * utils.InfoHashLen:
+		buf, err = hex.DecodeString(hash)
+	case 32:
+		buf, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+	default:
+		return fmt.Errorf("malformed info hash %q", hash)
+	}
+	if err != nil {
+		return fmt.Errorf("malformed info hash %q: %w", hash, err)
+	}
+	copy(dst[:], buf)
+	return nil
+}
@@ -18,6 +18,7 @@ const (
 	MsgRequest       MessageID = 6
 	MsgPiece         MessageID = 7
 	MsgCancel        MessageID = 8
+	MsgExtended      MessageID = 20
 )
 
 type Message struct {
@@ -81,6 +82,17 @@ func FormatPiece(index int, begin int, b []byte) *Message {
 	return &Message{ID: MsgPiece, Payload: append(payload, b...)}
 }
 
+// FormatPieceHeader serializes everything of a piece message except the
+// block itself, so the block can be written without copying it.
+func FormatPieceHeader(index int, begin int, length int) []byte {
+	buf := make([]byte, 13)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(length+9))
+	buf[4] = byte(MsgPiece)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(index))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(begin))
+	return buf
+}
+
 func FormatHave(index int) *Message {
 	payload := make([]byte, 4)
 	binary.BigEndian.PutUint32(payload, uint32(index))
@@ -134,26 +146,33 @@ func ParseHave(msg *Message) (int, error) {
 }
 
 func ParsePiece(index int, buf []byte, msg *Message) (int, error) {
+	_, n, err := ParseBlock(index, buf, msg)
+	return n, err
+}
+
+// ParseBlock copies the block of a piece message into buf and returns its
+// offset and length.
+func ParseBlock(index int, buf []byte, msg *Message) (begin int, n int, err error) {
 	if msg.ID != MsgPiece {
-		return 0, fmt.Errorf("expected PIECE (ID=%d), got ID=%d", MsgPiece, msg.ID)
+		return 0, 0, fmt.Errorf("expected PIECE (ID=%d), got ID=%d", MsgPiece, msg.ID)
 	}
 	if len(msg.Payload) < 8 {
-		return 0, fmt.Errorf("payload too short(%d < 8)", len(msg.Payload))
+		return 0, 0, fmt.Errorf("payload too short(%d < 8)", len(msg.Payload))
 	}
 	parsedIndex := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
 	if parsedIndex != index {
-		return 0, fmt.Errorf("expected index %d, got %d", index, parsedIndex)
+		return 0, 0, fmt.Errorf("expected index %d, got %d", index, parsedIndex)
 	}
-	begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
 	if begin >= len(buf) {
-		return 0, fmt.Errorf("begin offset too high(%d >= %d)", begin, len(buf))
+		return 0, 0, fmt.Errorf("begin offset too high(%d >= %d)", begin, len(buf))
 	}
 	data := msg.Payload[8:]
 	if begin+len(data) > len(buf) {
-		return 0, fmt.Errorf("data too long (%d) for offset %d with length %d", len(data), begin, len(buf))
+		return 0, 0, fmt.Errorf("data too long (%d) for offset %d with length %d", len(data), begin, len(buf))
 	}
 	copy(buf[begin:], data)
-	return len(data), nil
+	return begin, len(data), nil
 }
 
 func ParseRequest(msg *Message) (index int, begin int, length int, err error) {
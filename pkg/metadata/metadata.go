@@ -0,0 +1,230 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/handshake"
+	"github.com/DanArmor/GoTorrent/pkg/message"
+	"github.com/DanArmor/GoTorrent/pkg/peers"
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+	"github.com/jackpal/bencode-go"
+)
+
+// PieceSize is the size of the metadata pieces (BEP 9).
+const PieceSize = 16384
+
+// MaxSize limits the size of the metadata accepted from a peer.
+const MaxSize = 16 << 20
+
+// MaxConns is how many peers are asked at the same time.
+const MaxConns = 8
+
+// localID is the id our ut_metadata messages are sent to.
+const localID = 1
+
+const (
+	msgRequest = 0
+	msgData    = 1
+	msgReject  = 2
+)
+
+var ErrNoPeers = errors.New("no peer sent the metadata")
+
+type extHandshake struct {
+	M            map[string]int `bencode:"m"`
+	MetadataSize int            `bencode:"metadata_size,omitempty"`
+}
+
+type extMessage struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// Fetch downloads the info dictionary of a torrent from peers supporting the
+// extension protocol (BEP 10) and the metadata extension (BEP 9). The result
+// is verified against infoHash.
+func Fetch(ctx context.Context, ps []peers.Peer, infoHash [utils.InfoHashLen]byte, peerID [utils.PeerIDLen]byte) ([]byte, error) {
+	if len(ps) == 0 {
+		return nil, ErrNoPeers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan peers.Peer, len(ps))
+	for _, p := range ps {
+		queue <- p
+	}
+	close(queue)
+	result := make(chan []byte, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < MaxConns && i < len(ps); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+				info, err := fetchFrom(ctx, p, infoHash, peerID)
+				if err == nil {
+					select {
+					case result <- info:
+						cancel()
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case info := <-result:
+		return info, nil
+	default:
+	}
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	return nil, ErrNoPeers
+}
+
+func sendExtended(conn net.Conn, id int, msg interface{}, data []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(id))
+	if err := bencode.Marshal(&buf, msg); err != nil {
+		return err
+	}
+	buf.Write(data)
+	m := message.Message{ID: message.MsgExtended, Payload: buf.Bytes()}
+	_, err := conn.Write(m.Serialize())
+	return err
+}
+
+func fetchFrom(ctx context.Context, peer peers.Peer, infoHash [utils.InfoHashLen]byte, peerID [utils.PeerIDLen]byte) ([]byte, error) {
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	conn, err := d.DialContext(dialCtx, "tcp", peer.String())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	req := handshake.New(infoHash, peerID)
+	req.SetExtensions()
+	if _, err := conn.Write(req.Serialize()); err != nil {
+		return nil, err
+	}
+	res, err := handshake.Read(conn)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(res.InfoHash[:], infoHash[:]) {
+		return nil, fmt.Errorf("wrong infohash from %s", peer)
+	}
+	if !res.SupportsExtensions() {
+		return nil, fmt.Errorf("%s does not support extensions", peer)
+	}
+	hs := extHandshake{M: map[string]int{"ut_metadata": localID}}
+	if err := sendExtended(conn, 0, hs, nil); err != nil {
+		return nil, err
+	}
+
+	var info []byte
+	var have []bool
+	left := 0
+	remoteID := 0
+	for {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		msg, err := message.Read(conn)
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil || msg.ID != message.MsgExtended || len(msg.Payload) == 0 {
+			continue
+		}
+		r := bufio.NewReader(bytes.NewReader(msg.Payload[1:]))
+		switch msg.Payload[0] {
+		case 0:
+			if info != nil {
+				continue
+			}
+			var peerHs extHandshake
+			if err := bencode.Unmarshal(r, &peerHs); err != nil {
+				return nil, err
+			}
+			remoteID = peerHs.M["ut_metadata"]
+			if remoteID == 0 {
+				return nil, fmt.Errorf("%s does not support ut_metadata", peer)
+			}
+			if peerHs.MetadataSize <= 0 || peerHs.MetadataSize > MaxSize {
+				return nil, fmt.Errorf("%s sent metadata size %d", peer, peerHs.MetadataSize)
+			}
+			info = make([]byte, peerHs.MetadataSize)
+			left = (len(info) + PieceSize - 1) / PieceSize
+			have = make([]bool, left)
+			for piece := 0; piece < left; piece++ {
+				if err := sendExtended(conn, remoteID, extMessage{MsgType: msgRequest, Piece: piece}, nil); err != nil {
+					return nil, err
+				}
+			}
+		case localID:
+			if info == nil {
+				continue
+			}
+			var m extMessage
+			if err := bencode.Unmarshal(r, &m); err != nil {
+				return nil, err
+			}
+			if m.MsgType == msgReject {
+				return nil, fmt.Errorf("%s rejected metadata piece %d", peer, m.Piece)
+			}
+			if m.MsgType != msgData || m.Piece < 0 || m.Piece >= len(have) {
+				continue
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			begin := m.Piece * PieceSize
+			end := begin + PieceSize
+			if end > len(info) {
+				end = len(info)
+			}
+			if len(data) != end-begin {
+				return nil, fmt.Errorf("%s sent metadata piece %d of wrong size", peer, m.Piece)
+			}
+			copy(info[begin:end], data)
+			if !have[m.Piece] {
+				have[m.Piece] = true
+				left--
+			}
+			if left == 0 {
+				if sha1.Sum(info) != infoHash {
+					return nil, fmt.Errorf("metadata from %s does not match the info hash", peer)
+				}
+				return info, nil
+			}
+		}
+	}
+}
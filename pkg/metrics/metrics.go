@@ -0,0 +1,369 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+// ContentType is the type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Default is the registry the packages of GoTorrent are instrumented in.
+var Default = NewRegistry()
+
+// InfoHash formats a hash as the value of an info_hash label.
+func InfoHash(hash [utils.InfoHashLen]byte) string {
+	return hex.EncodeToString(hash[:])
+}
+
+// Collector is a metric family of a registry.
+type Collector interface {
+	Name() string
+	write(w *bufio.Writer)
+}
+
+// Registry writes its collectors sorted by name. Registering a name again
+// replaces its collector, while the constructors return the metric already
+// registered under the name, so packages can share a family.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+func (r *Registry) Register(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range cs {
+		r.collectors[c.Name()] = c
+	}
+}
+
+// lookup returns the collector registered under name as a T, or registers
+// the one made by create.
+func lookup[T Collector](r *Registry, name string, create func() T) T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.collectors[name].(T); ok {
+		return c
+	}
+	c := create()
+	r.collectors[name] = c
+	return c
+}
+
+func (r *Registry) Counter(name, help string) *Counter {
+	return lookup(r, name, func() *Counter {
+		return &Counter{name: name, help: help}
+	})
+}
+
+func (r *Registry) Gauge(name, help string) *Gauge {
+	return lookup(r, name, func() *Gauge {
+		return &Gauge{name: name, help: help}
+	})
+}
+
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	return lookup(r, name, func() *CounterVec {
+		return &CounterVec{vec[Counter]{name: name, help: help, typ: "counter", labels: labels}}
+	})
+}
+
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	return lookup(r, name, func() *GaugeVec {
+		return &GaugeVec{vec[Gauge]{name: name, help: help, typ: "gauge", labels: labels}}
+	})
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	return lookup(r, name, func() *Histogram {
+		return &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	})
+}
+
+// GaugeFunc registers a gauge whose values are read by fn when the metrics
+// are written. fn reports each series by calling observe.
+func (r *Registry) GaugeFunc(name, help string, labels []string, fn func(observe func(value float64, labelValues ...string))) {
+	r.Register(&gaugeFunc{name: name, help: help, labels: labels, fn: fn})
+}
+
+// Forget removes the series with the given label value from every vector,
+// such as those of a removed torrent.
+func (r *Registry) Forget(label, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.collectors {
+		if f, ok := c.(interface{ forget(label, value string) }); ok {
+			f.forget(label, value)
+		}
+	}
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	cs := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		cs = append(cs, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Name() < cs[j].Name() })
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range cs {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func header(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats label pairs as {a="1",b="2"}.
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter only goes up.
+type Counter struct {
+	name, help string
+	v          atomic.Int64
+}
+
+func (c *Counter) Name() string { return c.name }
+
+func (c *Counter) Add(n int) {
+	if n > 0 {
+		c.v.Add(int64(n))
+	}
+}
+
+func (c *Counter) Inc() { c.v.Add(1) }
+
+func (c *Counter) Value() int64 { return c.v.Load() }
+
+func (c *Counter) write(w *bufio.Writer) {
+	header(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.Value())
+}
+
+type Gauge struct {
+	name, help string
+	v          atomic.Int64
+}
+
+func (g *Gauge) Name() string { return g.name }
+
+func (g *Gauge) Set(n int)    { g.v.Store(int64(n)) }
+func (g *Gauge) Add(n int)    { g.v.Add(int64(n)) }
+func (g *Gauge) Inc()         { g.v.Add(1) }
+func (g *Gauge) Dec()         { g.v.Add(-1) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	header(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", g.name, g.Value())
+}
+
+type series[T any] struct {
+	values []string
+	metric *T
+}
+
+// vec is a family of metrics told apart by their label values.
+type vec[T any] struct {
+	name, help, typ string
+	labels          []string
+	mu              sync.Mutex
+	series          map[string]*series[T]
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.series == nil {
+		v.series = make(map[string]*series[T])
+	}
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{values: append([]string(nil), values...), metric: new(T)}
+		v.series[key] = s
+	}
+	return s.metric
+}
+
+func (v *vec[T]) forget(label, value string) {
+	i := -1
+	for j, l := range v.labels {
+		if l == label {
+			i = j
+		}
+	}
+	if i < 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, s := range v.series {
+		if s.values[i] == value {
+			delete(v.series, key)
+		}
+	}
+}
+
+func (v *vec[T]) Name() string { return v.name }
+
+func (v *vec[T]) writeSeries(w *bufio.Writer, value func(*T) int64) {
+	header(w, v.name, v.help, v.typ)
+	v.mu.Lock()
+	lines := make([]string, 0, len(v.series))
+	for _, s := range v.series {
+		lines = append(lines, fmt.Sprintf("%s%s %d\n", v.name, labelString(v.labels, s.values), value(s.metric)))
+	}
+	v.mu.Unlock()
+	sort.Strings(lines)
+	for _, line := range lines {
+		w.WriteString(line)
+	}
+}
+
+type CounterVec struct{ vec[Counter] }
+
+// With returns the counter of the label values, creating it if needed.
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeSeries(w, (*Counter).Value)
+}
+
+type GaugeVec struct{ vec[Gauge] }
+
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.writeSeries(w, (*Gauge).Value)
+}
+
+// Histogram counts observations in cumulative buckets of upper bounds.
+type Histogram struct {
+	name, help string
+	buckets    []float64
+	mu         sync.Mutex
+	counts     []uint64
+	count      uint64
+	sum        float64
+}
+
+// DurationBuckets suit latencies in seconds.
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+func (h *Histogram) Name() string { return h.name }
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	header(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+type gaugeFunc struct {
+	name, help string
+	labels     []string
+	fn         func(observe func(value float64, labelValues ...string))
+}
+
+func (g *gaugeFunc) Name() string { return g.name }
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	header(w, g.name, g.help, "gauge")
+	var lines []string
+	g.fn(func(value float64, values ...string) {
+		if len(values) != len(g.labels) {
+			return
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s\n", g.name, labelString(g.labels, values), formatFloat(value)))
+	})
+	sort.Strings(lines)
+	for _, line := range lines {
+		w.WriteString(line)
+	}
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("b_total", "Counted things.").Add(3)
+	r.Counter("b_total", "Ignored help.").Inc()
+	g := r.Gauge("a_gauge", "A gauge\nover lines.")
+	g.Set(5)
+	g.Dec()
+	pieces := r.CounterVec("c_pieces_total", "Pieces by torrent.", "info_hash", "result")
+	pieces.With("ff", "ok").Add(2)
+	pieces.With("aa", `say "hi"\`).Inc()
+	pieces.With("aa", "ok").Add(-1)
+	h := r.Histogram("d_seconds", "Latency.", []float64{0.5, 1})
+	for _, v := range []float64{0.25, 0.75, 2} {
+		h.Observe(v)
+	}
+	r.GaugeFunc("e_ratio", "Ratio.", []string{"info_hash"}, func(observe func(float64, ...string)) {
+		observe(0.5, "bb")
+		observe(math.Inf(1), "aa")
+		observe(1, "too", "many")
+	})
+
+	tests := []struct {
+		name   string
+		forget string
+		want   string
+	}{
+		{"all", "", `# HELP a_gauge A gauge\nover lines.
+# TYPE a_gauge gauge
+a_gauge 4
+# HELP b_total Counted things.
+# TYPE b_total counter
+b_total 4
+# HELP c_pieces_total Pieces by torrent.
+# TYPE c_pieces_total counter
+c_pieces_total{info_hash="aa",result="ok"} 0
+c_pieces_total{info_hash="aa",result="say \"hi\"\\"} 1
+c_pieces_total{info_hash="ff",result="ok"} 2
+# HELP d_seconds Latency.
+# TYPE d_seconds histogram
+d_seconds_bucket{le="0.5"} 1
+d_seconds_bucket{le="1"} 2
+d_seconds_bucket{le="+Inf"} 3
+d_seconds_sum 3
+d_seconds_count 3
+# HELP e_ratio Ratio.
+# TYPE e_ratio gauge
+e_ratio{info_hash="aa"} +Inf
+e_ratio{info_hash="bb"} 0.5
+`},
+		{"forgotten torrent", "aa", `# HELP a_gauge A gauge\nover lines.
+# TYPE a_gauge gauge
+a_gauge 4
+# HELP b_total Counted things.
+# TYPE b_total counter
+b_total 4
+# HELP c_pieces_total Pieces by torrent.
+# TYPE c_pieces_total counter
+c_pieces_total{info_hash="ff",result="ok"} 2
+# HELP d_seconds Latency.
+# TYPE d_seconds histogram
+d_seconds_bucket{le="0.5"} 1
+d_seconds_bucket{le="1"} 2
+d_seconds_bucket{le="+Inf"} 3
+d_seconds_sum 3
+d_seconds_count 3
+# HELP e_ratio Ratio.
+# TYPE e_ratio gauge
+e_ratio{info_hash="aa"} +Inf
+e_ratio{info_hash="bb"} 0.5
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.forget != "" {
+				r.Forget("info_hash", tt.forget)
+			}
+			var b strings.Builder
+			n, err := r.WriteTo(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(b.Len()) {
+				t.Errorf("WriteTo returned %d bytes, wrote %d", n, b.Len())
+			}
+			if b.String() != tt.want {
+				t.Errorf("WriteTo wrote\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("x_total", "X.").Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	if !strings.Contains(rec.Body.String(), "x_total 1\n") {
+		t.Errorf("body %q misses the counter", rec.Body.String())
+	}
+}
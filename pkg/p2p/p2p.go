@@ -5,44 +5,34 @@ import (
 	"context"
 	"crypto/sha1"
 	"fmt"
+	"log/slog"
 	"net"
-	"os"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/DanArmor/GoTorrent/pkg/bitfield"
 	"github.com/DanArmor/GoTorrent/pkg/client"
+	"github.com/DanArmor/GoTorrent/pkg/diskio"
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/logging"
 	"github.com/DanArmor/GoTorrent/pkg/message"
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
 	"github.com/DanArmor/GoTorrent/pkg/peers"
-	"github.com/DanArmor/GoTorrent/pkg/torrent"
+	"github.com/DanArmor/GoTorrent/pkg/ratelimit"
+	"github.com/DanArmor/GoTorrent/pkg/stats"
 	"github.com/DanArmor/GoTorrent/pkg/utils"
 )
 
-const MaxBlockSize = 16384
+const MaxBlockSize = diskio.MaxBlockSize
 
 const MaxBacklog = 5
 
-var LogStrings []string
-var LogMU sync.RWMutex
-
-func WriteToLog(str string) {
-	LogMU.Lock()
-	LogStrings = append(LogStrings, str)
-	LogMU.Unlock()
-}
-
-func GetLogString() string {
-	LogMU.RLock()
-	str := strings.Join(LogStrings, "\n")
-	LogMU.RUnlock()
-	return str
-}
-
-type File struct {
-	torrent.File
-	Handler *os.File
-}
+var (
+	downloadedBytes = metrics.Default.CounterVec("gotorrent_downloaded_bytes_total", "Piece data received from peers.", "info_hash")
+	hashFailures    = metrics.Default.CounterVec("gotorrent_hash_failures_total", "Pieces that failed their integrity check.", "info_hash")
+	peersConnected  = metrics.Default.GaugeVec("gotorrent_peers_connected", "Connected peers by how they were found.", "info_hash", "source")
+)
 
 type Torrent struct {
 	Peers       []peers.Peer
@@ -52,8 +42,20 @@ type Torrent struct {
 	PieceLength int
 	Name        string
 	Length      int
-	Files       []File
-	Bitfield    bitfield.Bitfield
+	TotalSize   int
+	Disk        *diskio.Disk
+	Logger      *slog.Logger
+	Events      *event.Bus
+	// Limiters throttle the data read from peers.
+	Limiters []*ratelimit.Limiter
+	// Stats counts the transfers, with a child for each peer.
+	Stats      *stats.Stats
+	Bitfield   bitfield.Bitfield
+	Priorities []int
+	// Blocks holds the blocks already on disk for pieces that are not
+	// complete. It is updated while downloading.
+	Blocks   map[int]bitfield.Bitfield
+	blocksMu sync.Mutex
 }
 
 type pieceWork struct {
@@ -67,12 +69,19 @@ type pieceResult struct {
 	buf   []byte
 }
 
+type pieceWritten struct {
+	index int
+	err   error
+}
+
 type pieceProgress struct {
 	index      int
 	client     *client.Client
 	buf        []byte
+	have       bitfield.Bitfield
+	received   bitfield.Bitfield
 	downloaded int
-	requested  int
+	nextBlock  int
 	backlog    int
 }
 
@@ -88,9 +97,9 @@ func (state *pieceProgress) readMessage() error {
 
 	switch msg.ID {
 	case message.MsgUnchoke:
-		state.client.Choked = false
+		state.client.SetChoked(false)
 	case message.MsgChoke:
-		state.client.Choked = true
+		state.client.SetChoked(true)
 	case message.MsgHave:
 		index, err := message.ParseHave(msg)
 		if err != nil {
@@ -98,21 +107,51 @@ func (state *pieceProgress) readMessage() error {
 		}
 		state.client.Bitfield.SetPiece(index)
 	case message.MsgPiece:
-		n, err := message.ParsePiece(state.index, state.buf, msg)
+		begin, n, err := message.ParseBlock(state.index, state.buf, msg)
 		if err != nil {
 			return err
 		}
-		state.downloaded += n
+		downloadedBytes.With(metrics.InfoHash(state.client.InfoHash)).Add(n)
+		state.client.Stats.AddDownloaded(n)
+		block := begin / MaxBlockSize
+		if !state.have.HasPiece(block) && !state.received.HasPiece(block) {
+			state.received.SetPiece(block)
+			state.downloaded += n
+		}
 		state.backlog--
 	}
 	return nil
 }
 
-func attemptDownloadPiece(ctx context.Context, c *client.Client, pw *pieceWork) ([]byte, error) {
+func blockCount(length int) int {
+	return (length + MaxBlockSize - 1) / MaxBlockSize
+}
+
+func blockBounds(length int, block int) (begin int, end int) {
+	begin = block * MaxBlockSize
+	end = begin + MaxBlockSize
+	if end > length {
+		end = length
+	}
+	return begin, end
+}
+
+// attemptDownloadPiece requests the blocks of the piece that are not in have
+// and returns the piece buffer along with the blocks received. On error the
+// received blocks are still valid and can be kept.
+func attemptDownloadPiece(ctx context.Context, c *client.Client, pw *pieceWork, buf []byte, have bitfield.Bitfield) ([]byte, bitfield.Bitfield, error) {
 	state := pieceProgress{
-		index:  pw.index,
-		client: c,
-		buf:    make([]byte, pw.length),
+		index:    pw.index,
+		client:   c,
+		buf:      buf,
+		have:     have,
+		received: make(bitfield.Bitfield, blockCount(pw.length)/8+1),
+	}
+	for block := 0; block < blockCount(pw.length); block++ {
+		if have.HasPiece(block) {
+			begin, end := blockBounds(pw.length, block)
+			state.downloaded += end - begin
+		}
 	}
 	c.Conn.SetDeadline(time.Now().Add(1 * time.Second))
 	defer c.Conn.SetDeadline(time.Time{})
@@ -120,25 +159,26 @@ func attemptDownloadPiece(ctx context.Context, c *client.Client, pw *pieceWork)
 	for state.downloaded < pw.length {
 		select {
 		case <-ctx.Done():
-			return nil, fmt.Errorf("stopped by context")
+			return state.buf, state.received, fmt.Errorf("stopped by context")
 		default:
 			if !state.client.Choked {
-				for state.backlog < MaxBacklog && state.requested < pw.length {
-					blockSize := MaxBlockSize
-					if pw.length-state.requested < blockSize {
-						blockSize = pw.length - state.requested
+				for state.backlog < MaxBacklog && state.nextBlock < blockCount(pw.length) {
+					if have.HasPiece(state.nextBlock) {
+						state.nextBlock++
+						continue
 					}
-					err := c.SendRequest(pw.index, state.requested, blockSize)
+					begin, end := blockBounds(pw.length, state.nextBlock)
+					err := c.SendRequest(pw.index, begin, end-begin)
 					if err != nil {
 						if err, ok := err.(net.Error); ok && err.Timeout() && timeoutCounter != 0 {
 							c.Conn.SetDeadline(time.Now().Add(1 * time.Second))
 							timeoutCounter--
 							continue
 						}
-						return nil, err
+						return state.buf, state.received, err
 					}
 					state.backlog++
-					state.requested += blockSize
+					state.nextBlock++
 				}
 			}
 			err := state.readMessage()
@@ -148,12 +188,63 @@ func attemptDownloadPiece(ctx context.Context, c *client.Client, pw *pieceWork)
 					timeoutCounter--
 					continue
 				}
-				return nil, err
+				return state.buf, state.received, err
 			}
 		}
 	}
 
-	return state.buf, nil
+	return state.buf, state.received, nil
+}
+
+// loadBlocks reads the blocks of the piece kept from earlier attempts.
+func (t *Torrent) loadBlocks(pw *pieceWork) ([]byte, bitfield.Bitfield) {
+	buf := make([]byte, pw.length)
+	have := make(bitfield.Bitfield, blockCount(pw.length)/8+1)
+	t.blocksMu.Lock()
+	saved := t.Blocks[pw.index]
+	t.blocksMu.Unlock()
+	for block := 0; block < blockCount(pw.length); block++ {
+		if !saved.HasPiece(block) {
+			continue
+		}
+		begin, end := blockBounds(pw.length, block)
+		if err := t.Disk.Storage().ReadPiece(pw.index, begin, buf[begin:end]); err == nil {
+			have.SetPiece(block)
+		}
+	}
+	return buf, have
+}
+
+// saveBlocks writes the received blocks of an unfinished piece to their place
+// on disk and remembers them, so a later attempt does not request them again.
+func (t *Torrent) saveBlocks(pw *pieceWork, buf []byte, received bitfield.Bitfield) {
+	for block := 0; block < blockCount(pw.length); block++ {
+		if !received.HasPiece(block) {
+			continue
+		}
+		begin, end := blockBounds(pw.length, block)
+		index, b := pw.index, block
+		t.Disk.Write(index, begin, buf[begin:end], func(err error) {
+			if err != nil {
+				return
+			}
+			t.blocksMu.Lock()
+			defer t.blocksMu.Unlock()
+			if t.Blocks == nil {
+				t.Blocks = make(map[int]bitfield.Bitfield)
+			}
+			if t.Blocks[index] == nil {
+				t.Blocks[index] = make(bitfield.Bitfield, blockCount(pw.length)/8+1)
+			}
+			t.Blocks[index].SetPiece(b)
+		})
+	}
+}
+
+func (t *Torrent) dropBlocks(index int) {
+	t.blocksMu.Lock()
+	delete(t.Blocks, index)
+	t.blocksMu.Unlock()
 }
 
 func checkIntegrity(pw *pieceWork, buf []byte) error {
@@ -165,13 +256,26 @@ func checkIntegrity(pw *pieceWork, buf []byte) error {
 }
 
 func (t *Torrent) startDownloadWorker(ctx context.Context, peer peers.Peer, workQueue chan *pieceWork, results chan *pieceResult) {
+	log := t.Logger.With(logging.Peer(peer.String()))
 	c, err := client.New(peer, t.PeerID, t.InfoHash)
 	if err != nil {
-		WriteToLog(fmt.Sprintf("Could not handshake with %s. Disconnected", peer.IP))
+		log.Debug("Could not handshake", "err", err)
 		return
 	}
-	defer c.Conn.Close()
-	WriteToLog(fmt.Sprintf("Completed handshake with %s", peer.IP))
+	defer c.Close()
+	ps := t.Stats.AddPeer(peer.String())
+	defer t.Stats.RemovePeer(ps)
+	// The handshake and the bitfield were exchanged before counting.
+	ps.AddWritten(utils.HandshakeSize)
+	ps.AddRead(utils.HandshakeSize + 5 + len(c.Bitfield))
+	c.Stats = ps
+	c.Conn = stats.NewConn(ratelimit.NewConn(c.Conn, t.Limiters, nil), ps)
+	log.Debug("Completed handshake")
+	connected := peersConnected.With(metrics.InfoHash(t.InfoHash), "tracker")
+	connected.Inc()
+	defer connected.Dec()
+	t.Events.Publish(event.Event{Type: event.PeerConnected, InfoHash: t.InfoHash, Peer: peer.String()})
+	defer t.Events.Publish(event.Event{Type: event.PeerDisconnected, InfoHash: t.InfoHash, Peer: peer.String()})
 
 	c.SendUnchoke()
 	c.SendInterested()
@@ -185,20 +289,29 @@ func (t *Torrent) startDownloadWorker(ctx context.Context, peer peers.Peer, work
 				workQueue <- pw
 				continue
 			}
-			buf, err := attemptDownloadPiece(ctx, c, pw)
+			buf, have := t.loadBlocks(pw)
+			buf, received, err := attemptDownloadPiece(ctx, c, pw, buf, have)
 			if err != nil {
-				WriteToLog(fmt.Sprint("Exiting: ", err))
+				log.Debug("Disconnecting", "piece", pw.index, "err", err)
+				t.saveBlocks(pw, buf, received)
 				workQueue <- pw
 				return
 			}
 			err = checkIntegrity(pw, buf)
 			if err != nil {
-				WriteToLog(fmt.Sprintf("Piece %d failed integrity check", pw.index))
+				log.Warn("Piece failed integrity check", "piece", pw.index)
+				hashFailures.With(metrics.InfoHash(t.InfoHash)).Inc()
+				t.Events.Publish(event.Event{Type: event.HashFailed, InfoHash: t.InfoHash, Piece: pw.index, Peer: peer.String()})
+				t.dropBlocks(pw.index)
 				workQueue <- pw
 				continue
 			}
 			c.SendHave(pw.index)
-			results <- &pieceResult{index: pw.index, buf: buf}
+			select {
+			case results <- &pieceResult{index: pw.index, buf: buf}:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -206,8 +319,8 @@ func (t *Torrent) startDownloadWorker(ctx context.Context, peer peers.Peer, work
 func (t *Torrent) calculateBoundsForPiece(index int) (begin int, end int) {
 	begin = index * t.PieceLength
 	end = begin + t.PieceLength
-	if end > t.Files[len(t.Files)-1].End {
-		end = t.Files[len(t.Files)-1].End
+	if end > t.TotalSize {
+		end = t.TotalSize
 	}
 	return begin, end
 }
@@ -217,59 +330,39 @@ func (t *Torrent) calculatePieceSize(index int) int {
 	return end - begin
 }
 
-func (t *Torrent) writeToFile(pr pieceResult) {
-	begin, _ := t.calculateBoundsForPiece(pr.index)
-	index := -1
-	for i := range t.Files {
-		if begin <= t.Files[i].Begin {
-			index = i-1
-			break
-		}
-	}
-	if index == -1{
-		index = len(t.Files)-1
-	}
-	pieceLength := t.calculatePieceSize(pr.index)
-	wrote := 0
-	for i := index; i < len(t.Files); i++ {
-		startInFile := 0
-		if wrote == 0 {
-			startInFile = begin - t.Files[i].Begin
-		}
-		endInFile := startInFile + pieceLength
-		if endInFile > t.Files[i].Length {
-			endInFile = t.Files[i].Length
-		}
-		//fmt.Print(fmt.Sprintf("i:%d wrote: %d, startInFile: %d  endInFile%d  len(pr.buf):%d  pieceLenght %d\n", pr.index, wrote, startInFile, endInFile, len(pr.buf), pieceLength))
-		t.Files[i].Handler.WriteAt(pr.buf[wrote:wrote+endInFile-startInFile], int64(startInFile))
-		wrote += endInFile - startInFile
-		pieceLength -= endInFile - startInFile
-		//WriteToLog(fmt.Sprintf("---wrote: %d, startInFile: %d  endInFile%d  len(pr.buf):%d  pieceLenght %d", wrote, startInFile, endInFile, len(pr.buf), pieceLength))
-		if pieceLength == 0 {
-			break
-		}
+func (t *Torrent) piecePriority(index int) int {
+	if t.Priorities == nil {
+		return 1
 	}
+	return t.Priorities[index]
 }
 
-func (t *Torrent) Download(done chan struct{}, count chan int) {
-	WriteToLog(fmt.Sprintf("Starting downloading <%s>", t.Name))
-	workQueue := make(chan *pieceWork, len(t.PieceHashes))
-	results := make(chan *pieceResult, len(t.PieceHashes)/4)
-	donePieces := len(t.PieceHashes)
-	for index, hash := range t.PieceHashes {
-		if !t.Bitfield.HasPiece(index) {
-			length := t.calculatePieceSize(index)
-			workQueue <- &pieceWork{index, hash, length}
-			donePieces--
+func (t *Torrent) Download(done chan struct{}, count chan int) error {
+	t.Logger = logging.OrDiscard(t.Logger)
+	t.Logger.Info("Starting download", "name", t.Name)
+	var order []int
+	for index := range t.PieceHashes {
+		if !t.Bitfield.HasPiece(index) && t.piecePriority(index) > 0 {
+			order = append(order, index)
 		}
 	}
-	if donePieces == len(t.PieceHashes) {
-		return
+	sort.SliceStable(order, func(i, j int) bool {
+		return t.piecePriority(order[i]) > t.piecePriority(order[j])
+	})
+	if len(order) == 0 {
+		close(count)
+		return nil
 	}
+	workQueue := make(chan *pieceWork, len(order))
+	results := make(chan *pieceResult, len(order)/4)
+	for _, index := range order {
+		workQueue <- &pieceWork{index, t.PieceHashes[index], t.calculatePieceSize(index)}
+	}
+	leftPieces := len(order)
 
 	var wg sync.WaitGroup
 
-	WriteToLog(fmt.Sprintf("Peers: %d", len(t.Peers)))
+	t.Logger.Info("Connecting to peers", "peers", len(t.Peers))
 	ctx, cancel := context.WithCancel(context.Background())
 	for _, peer := range t.Peers {
 		wg.Add(1)
@@ -279,25 +372,64 @@ func (t *Torrent) Download(done chan struct{}, count chan int) {
 		}(peer)
 	}
 
+	// Pieces are written by the disk workers, and the callbacks must never
+	// block them, so there is room for every piece in the channel.
+	written := make(chan pieceWritten, len(order))
+	pending := 0
+	var diskErr error
+	// A failed write stops the download: writing the piece again would
+	// most likely fail the same way.
+	onWritten := func(res pieceWritten) {
+		if res.err != nil {
+			t.Logger.Error("Could not write piece", "piece", res.index, "err", res.err)
+			t.Events.Publish(event.Event{Type: event.StorageError, InfoHash: t.InfoHash, Piece: res.index, Message: res.err.Error()})
+			if diskErr == nil {
+				diskErr = fmt.Errorf("could not write piece %d: %w", res.index, res.err)
+			}
+			return
+		}
+		leftPieces--
+		count <- res.index
+		t.Bitfield.SetPiece(res.index)
+		t.dropBlocks(res.index)
+		t.Events.Publish(event.Event{Type: event.PieceVerified, InfoHash: t.InfoHash, Piece: res.index})
+	}
+
 out:
-	for donePieces < len(t.PieceHashes) {
+	for leftPieces > 0 {
 		select {
 		case <-done:
 			cancel()
 			break out
 		case res := <-results:
-			t.writeToFile(*res)
-			donePieces++
-			count <- res.index
-			t.Bitfield.SetPiece(res.index)
+			pending++
+			index := res.index
+			t.Disk.Write(index, 0, res.buf, func(err error) {
+				written <- pieceWritten{index: index, err: err}
+			})
+		case res := <-written:
+			pending--
+			onWritten(res)
+			if diskErr != nil {
+				break out
+			}
 		}
 	}
 	cancel()
-	for i := range t.Files {
-		t.Files[i].Handler.Close()
+	for ; pending > 0; pending-- {
+		onWritten(<-written)
 	}
 	wg.Wait()
+	t.Disk.Wait()
+	t.blocksMu.Lock()
+	for index := range t.Blocks {
+		if t.Bitfield.HasPiece(index) {
+			delete(t.Blocks, index)
+		}
+	}
+	t.blocksMu.Unlock()
 	close(workQueue)
 	close(count)
 	close(results)
+	return diskErr
 }
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket refilled with rate bytes per second. It holds
+// at most one second worth of tokens. A nil Limiter or a rate of zero does
+// not limit.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int
+	tokens float64
+	last   time.Time
+}
+
+func New(rate int) *Limiter {
+	l := &Limiter{}
+	l.SetRate(rate)
+	return l
+}
+
+func (l *Limiter) Rate() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// SetRate changes the rate. Zero or less removes the limit.
+func (l *Limiter) SetRate(rate int) {
+	if rate < 0 {
+		rate = 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = float64(rate)
+	l.last = time.Now()
+}
+
+// reserve takes n tokens and returns how long to wait until they are
+// available. Taking more tokens than the bucket holds leaves it in debt.
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// Wait blocks until n bytes may pass or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	d := l.reserve(n)
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitAll waits on every limiter for n bytes.
+func WaitAll(ctx context.Context, limiters []*Limiter, n int) error {
+	var longest time.Duration
+	for _, l := range limiters {
+		if d := l.reserve(n); d > longest {
+			longest = d
+		}
+	}
+	if longest == 0 {
+		return nil
+	}
+	t := time.NewTimer(longest)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Conn throttles the data read from and written to a connection.
+type Conn struct {
+	net.Conn
+	read  []*Limiter
+	write []*Limiter
+}
+
+func NewConn(conn net.Conn, read []*Limiter, write []*Limiter) *Conn {
+	return &Conn{Conn: conn, read: read, write: write}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		WaitAll(context.Background(), c.read, n)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	WaitAll(context.Background(), c.write, len(b))
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,120 @@
+package recheck
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DanArmor/GoTorrent/pkg/bitfield"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+// State is the progress of a recheck. It can be saved and passed to New to
+// continue an interrupted recheck.
+type State struct {
+	Checked bitfield.Bitfield `json:"checked"`
+	Have    bitfield.Bitfield `json:"have"`
+}
+
+func NewState(pieces int) *State {
+	return &State{
+		Checked: make(bitfield.Bitfield, pieces/8+1),
+		Have:    make(bitfield.Bitfield, pieces/8+1),
+	}
+}
+
+type Job struct {
+	st      storage.Storage
+	hashes  [][utils.PieceHashLen]byte
+	workers int
+	mu      sync.Mutex
+	state   *State
+	checked int64
+	total   int
+}
+
+// New creates a job that hashes every piece not yet checked in state. A nil
+// state starts a full recheck. workers defaults to the number of CPUs.
+func New(st storage.Storage, hashes [][utils.PieceHashLen]byte, state *State, workers int) *Job {
+	if state == nil {
+		state = NewState(len(hashes))
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	j := &Job{
+		st:      st,
+		hashes:  hashes,
+		workers: workers,
+		state:   state,
+		total:   len(hashes),
+	}
+	for i := range hashes {
+		if state.Checked.HasPiece(i) {
+			j.checked++
+		}
+	}
+	return j
+}
+
+func (j *Job) Progress() (checked int, total int) {
+	return int(atomic.LoadInt64(&j.checked)), j.total
+}
+
+// State returns a copy of the progress made so far.
+func (j *Job) State() *State {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return &State{
+		Checked: append(bitfield.Bitfield(nil), j.state.Checked...),
+		Have:    append(bitfield.Bitfield(nil), j.state.Have...),
+	}
+}
+
+// Run hashes the remaining pieces and returns the bitfield of pieces with
+// valid data. Pieces that can not be read are reported as missing. When ctx
+// is cancelled Run returns its error and the job can be resumed from State.
+func (j *Job) Run(ctx context.Context) (bitfield.Bitfield, error) {
+	pieces := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < j.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range pieces {
+				ok, err := j.st.VerifyPiece(index, j.hashes[index])
+				j.mu.Lock()
+				if err == nil && ok {
+					j.state.Have.SetPiece(index)
+				}
+				j.state.Checked.SetPiece(index)
+				j.mu.Unlock()
+				atomic.AddInt64(&j.checked, 1)
+			}
+		}()
+	}
+	var err error
+out:
+	for i := range j.hashes {
+		j.mu.Lock()
+		checked := j.state.Checked.HasPiece(i)
+		j.mu.Unlock()
+		if checked {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break out
+		case pieces <- i:
+		}
+	}
+	close(pieces)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return j.State().Have, nil
+}
@@ -0,0 +1,67 @@
+package resume
+
+import (
+	"os"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/bitfield"
+)
+
+type FileState struct {
+	Exists  bool      `json:"exists"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Data is what is needed to continue a torrent without rechecking it: the
+// state of its files when the pieces were last known to be valid.
+type Data struct {
+	Files    []FileState       `json:"files"`
+	Bitfield bitfield.Bitfield `json:"bitfield"`
+	// Blocks holds the received blocks of pieces that are not complete yet.
+	Blocks map[int]bitfield.Bitfield `json:"blocks,omitempty"`
+}
+
+func statFile(path string) FileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return FileState{}
+	}
+	return FileState{
+		Exists:  true,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+}
+
+func Capture(paths []string, bf bitfield.Bitfield, blocks map[int]bitfield.Bitfield) *Data {
+	d := &Data{
+		Files:    make([]FileState, len(paths)),
+		Bitfield: append(bitfield.Bitfield(nil), bf...),
+		Blocks:   make(map[int]bitfield.Bitfield, len(blocks)),
+	}
+	for i := range paths {
+		d.Files[i] = statFile(paths[i])
+	}
+	for index, b := range blocks {
+		d.Blocks[index] = append(bitfield.Bitfield(nil), b...)
+	}
+	return d
+}
+
+// Changed returns the indexes of files that were created, deleted or
+// modified since the data was captured.
+func (d *Data) Changed(paths []string) []int {
+	var changed []int
+	for i := range paths {
+		if i >= len(d.Files) {
+			changed = append(changed, i)
+			continue
+		}
+		was, now := d.Files[i], statFile(paths[i])
+		if was.Exists != now.Exists || was.Size != now.Size || !was.ModTime.Equal(now.ModTime) {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
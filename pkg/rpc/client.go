@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Client calls the API of a daemon.
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+	id    atomic.Int64
+}
+
+// NewClient returns a client of the daemon at addr. network is "unix" for
+// a socket path or "tcp" for a host:port.
+func NewClient(network string, addr string, token string) *Client {
+	c := &Client{url: "http://" + addr, token: token, http: &http.Client{}}
+	if network == "unix" {
+		c.url = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", addr)
+			},
+		}
+	}
+	return c
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("daemon replied %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// Call calls method with params and decodes the result into result, which
+// may be nil.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	call := struct {
+		Version string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params,omitempty"`
+		ID      int64       `json:"id"`
+	}{Version, method, params, c.id.Add(1)}
+	body, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/rpc", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var r Response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return err
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+// Events calls fn for every event of the daemon until ctx is done or fn
+// returns an error. types, when given, filters the events.
+func (c *Client) Events(ctx context.Context, types []string, fn func(Event) error) error {
+	path := "/events"
+	if len(types) > 0 {
+		path += "?types=" + url.QueryEscape(strings.Join(types, ","))
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return sc.Err()
+}
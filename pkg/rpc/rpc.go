@@ -0,0 +1,242 @@
+// Package rpc is the JSON-RPC 2.0 control API of a session, served over
+// HTTP. Calls are POSTed to /rpc and events are streamed from /events as
+// JSON lines.
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/session"
+	"github.com/DanArmor/GoTorrent/pkg/stats"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+const Version = "2.0"
+
+const (
+	MethodAdd                  = "torrent.add"
+	MethodAddMagnet            = "torrent.add_magnet"
+	MethodList                 = "torrent.list"
+	MethodStatus               = "torrent.status"
+	MethodPause                = "torrent.pause"
+	MethodResume               = "torrent.resume"
+	MethodRemove               = "torrent.remove"
+	MethodSetPriority          = "torrent.set_priority"
+	MethodSetLimits            = "torrent.set_limits"
+	MethodPeers                = "torrent.peers"
+	MethodSessionLimits        = "session.limits"
+	MethodSessionSetLimits     = "session.set_limits"
+	MethodSessionStats         = "session.stats"
+	MethodSetSeedLimits        = "torrent.set_seed_limits"
+	MethodSessionSeedLimits    = "session.seed_limits"
+	MethodSessionSetSeedLimits = "session.set_seed_limits"
+	MethodQueueMove            = "torrent.queue_move"
+	MethodSessionQueue         = "session.queue"
+	MethodSessionSetQueue      = "session.set_queue"
+	MethodSetLabel             = "torrent.set_label"
+	MethodCategories           = "session.categories"
+	MethodSetCategory          = "session.set_category"
+	MethodRemoveCategory       = "session.remove_category"
+)
+
+// Error codes of JSON-RPC 2.0. CodeFailed is returned when the session
+// rejects a call.
+const (
+	CodeParse          = -32700
+	CodeInvalidRequest = -32600
+	CodeNoMethod       = -32601
+	CodeInvalidParams  = -32602
+	CodeFailed         = -32000
+)
+
+type Request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type Response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type AddParams struct {
+	// Metainfo is the content of a torrent file.
+	Metainfo []byte `json:"metainfo"`
+	// Label puts the torrent in a category.
+	Label string `json:"label,omitempty"`
+}
+
+type AddMagnetParams struct {
+	URI   string `json:"uri"`
+	Label string `json:"label,omitempty"`
+}
+
+type HashParams struct {
+	InfoHash string `json:"info_hash"`
+}
+
+type RemoveParams struct {
+	InfoHash   string `json:"info_hash"`
+	DeleteData bool   `json:"delete_data,omitempty"`
+}
+
+type PriorityParams struct {
+	InfoHash string `json:"info_hash"`
+	File     int    `json:"file"`
+	Priority string `json:"priority"`
+}
+
+// Limits are in bytes per second. Zero means no limit.
+type Limits struct {
+	InfoHash string `json:"info_hash,omitempty"`
+	Download int    `json:"download"`
+	Upload   int    `json:"upload"`
+}
+
+// SeedLimits are the seeding limits of a torrent, or of the session when
+// InfoHash is empty. Times are in minutes.
+type SeedLimits struct {
+	InfoHash string `json:"info_hash,omitempty"`
+	torrentmeta.SeedLimits
+}
+
+type QueueMoveParams struct {
+	InfoHash string `json:"info_hash"`
+	// Move is up, down, top or bottom.
+	Move string `json:"move"`
+}
+
+type LabelParams struct {
+	InfoHash string `json:"info_hash"`
+	Label    string `json:"label"`
+}
+
+// CategoryParams name a category, and carry its settings for
+// session.set_category.
+type CategoryParams struct {
+	Name string `json:"name"`
+	session.Category
+}
+
+type AddResult struct {
+	InfoHash string `json:"info_hash"`
+}
+
+type Torrent struct {
+	InfoHash      string  `json:"info_hash"`
+	Name          string  `json:"name"`
+	Label         string  `json:"label,omitempty"`
+	State         string  `json:"state"`
+	Checked       float64 `json:"checked,omitempty"`
+	Size          int     `json:"size"`
+	Progress      float64 `json:"progress"`
+	SavePath      string  `json:"save_path"`
+	Error         string  `json:"error,omitempty"`
+	DownloadLimit int     `json:"download_limit,omitempty"`
+	UploadLimit   int     `json:"upload_limit,omitempty"`
+	Downloaded    int64   `json:"downloaded"`
+	Uploaded      int64   `json:"uploaded"`
+	Ratio         float64 `json:"ratio"`
+	DownloadRate  int     `json:"download_rate"`
+	UploadRate    int     `json:"upload_rate"`
+	Peers         int     `json:"peers"`
+	QueuePosition int     `json:"queue_position"`
+	// SeedTime is in seconds. SeedGoal describes what is left of the
+	// seeding limits.
+	SeedTime int64  `json:"seed_time"`
+	SeedGoal string `json:"seed_goal"`
+}
+
+type Peer struct {
+	Addr string `json:"addr"`
+	stats.Counters
+	DownloadRate int `json:"download_rate"`
+	UploadRate   int `json:"upload_rate"`
+}
+
+type SessionStats struct {
+	Session      stats.Counters `json:"session"`
+	AllTime      stats.Counters `json:"all_time"`
+	DownloadRate int            `json:"download_rate"`
+	UploadRate   int            `json:"upload_rate"`
+	StartedAt    time.Time      `json:"started_at"`
+}
+
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	InfoHash string    `json:"info_hash,omitempty"`
+	Piece    int       `json:"piece"`
+	Peer     string    `json:"peer,omitempty"`
+	Progress float64   `json:"progress,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+func torrentOf(st session.Status) Torrent {
+	return Torrent{
+		InfoHash:      hex.EncodeToString(st.InfoHash[:]),
+		Name:          st.Name,
+		Label:         st.Label,
+		State:         string(st.State),
+		Checked:       st.Checked,
+		Size:          st.Size,
+		Progress:      st.Progress,
+		SavePath:      st.SavePath,
+		Error:         st.Error,
+		DownloadLimit: st.DownloadLimit,
+		UploadLimit:   st.UploadLimit,
+		Downloaded:    st.Downloaded,
+		Uploaded:      st.Uploaded,
+		Ratio:         st.Ratio,
+		DownloadRate:  st.DownloadRate,
+		UploadRate:    st.UploadRate,
+		Peers:         st.Peers,
+		QueuePosition: st.QueuePosition,
+		SeedTime:      int64(st.SeedTime / time.Second),
+		SeedGoal:      st.SeedGoal.String(),
+	}
+}
+
+func eventOf(e event.Event) Event {
+	ev := Event{
+		Type:     string(e.Type),
+		Time:     e.Time,
+		Piece:    e.Piece,
+		Peer:     e.Peer,
+		Progress: e.Progress,
+		Message:  e.Message,
+	}
+	if e.InfoHash != ([utils.InfoHashLen]byte{}) {
+		ev.InfoHash = hex.EncodeToString(e.InfoHash[:])
+	}
+	return ev
+}
+
+// ParseHash parses an info hash given in hex.
+func ParseHash(s string) (session.InfoHash, error) {
+	var hash session.InfoHash
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(hash) {
+		return hash, fmt.Errorf("invalid info hash %q", s)
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
@@ -0,0 +1,448 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/magnet"
+	"github.com/DanArmor/GoTorrent/pkg/session"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// MagnetTimeout bounds the metadata fetch of magnet links added over RPC.
+const MagnetTimeout = 5 * time.Minute
+
+// maxRequestSize bounds the body of a call, which may carry a torrent file.
+const maxRequestSize = 32 << 20
+
+type method func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// Server serves the API of a session.
+type Server struct {
+	s       *session.Session
+	methods map[string]method
+}
+
+func NewServer(s *session.Session) *Server {
+	srv := &Server{s: s}
+	srv.methods = map[string]method{
+		MethodAdd:                  srv.add,
+		MethodAddMagnet:            srv.addMagnet,
+		MethodList:                 srv.list,
+		MethodStatus:               srv.status,
+		MethodPause:                srv.pause,
+		MethodResume:               srv.resume,
+		MethodRemove:               srv.remove,
+		MethodSetPriority:          srv.setPriority,
+		MethodSetLimits:            srv.setLimits,
+		MethodPeers:                srv.peers,
+		MethodSessionLimits:        srv.sessionLimits,
+		MethodSessionSetLimits:     srv.sessionSetLimits,
+		MethodSessionStats:         srv.sessionStats,
+		MethodSetSeedLimits:        srv.setSeedLimits,
+		MethodSessionSeedLimits:    srv.sessionSeedLimits,
+		MethodSessionSetSeedLimits: srv.sessionSetSeedLimits,
+		MethodQueueMove:            srv.queueMove,
+		MethodSessionQueue:         srv.sessionQueue,
+		MethodSessionSetQueue:      srv.sessionSetQueue,
+		MethodSetLabel:             srv.setLabel,
+		MethodCategories:           srv.categories,
+		MethodSetCategory:          srv.setCategory,
+		MethodRemoveCategory:       srv.removeCategory,
+	}
+	return srv
+}
+
+// Handler returns the handler of /rpc and /events.
+func (srv *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc", srv.serveRPC)
+	mux.HandleFunc("/events", srv.serveEvents)
+	return mux
+}
+
+// RequireToken rejects requests that do not carry the bearer token. The
+// token is also accepted as the password of basic authentication, which is
+// all Transmission clients can send. An empty token lets every request
+// through.
+func RequireToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, got, ok = r.BasicAuth()
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (srv *Server) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req Request
+	resp := Response{Version: Version, ID: json.RawMessage("null")}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		resp.Error = &Error{Code: CodeParse, Message: err.Error()}
+	} else if req.Version != Version || req.Method == "" {
+		resp.Error = &Error{Code: CodeInvalidRequest, Message: "invalid request"}
+	} else {
+		if req.ID != nil {
+			resp.ID = req.ID
+		}
+		resp.Result, resp.Error = srv.call(r.Context(), req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (srv *Server) call(ctx context.Context, req Request) (json.RawMessage, *Error) {
+	m, ok := srv.methods[req.Method]
+	if !ok {
+		return nil, &Error{Code: CodeNoMethod, Message: "unknown method " + req.Method}
+	}
+	result, err := m(ctx, req.Params)
+	if err != nil {
+		var rerr *Error
+		if errors.As(err, &rerr) {
+			return nil, rerr
+		}
+		return nil, &Error{Code: CodeFailed, Message: err.Error()}
+	}
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return nil, &Error{Code: CodeFailed, Message: err.Error()}
+	}
+	return buf, nil
+}
+
+// serveEvents streams the events of the session as JSON lines until the
+// client goes away. The types query parameter, a comma separated list,
+// filters the events.
+func (srv *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	types := make(map[string]bool)
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t != "" {
+			types[t] = true
+		}
+	}
+	sub := srv.s.Subscribe(0)
+	defer sub.Close()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if len(types) > 0 && !types[string(e.Type)] {
+				continue
+			}
+			if err := enc.Encode(eventOf(e)); err != nil {
+				return
+			}
+			if len(sub.C) == 0 {
+				bw.Flush()
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return &Error{Code: CodeInvalidParams, Message: "missing params"}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func decodeHash(params json.RawMessage) (session.InfoHash, error) {
+	var p HashParams
+	if err := decode(params, &p); err != nil {
+		return session.InfoHash{}, err
+	}
+	return parseHashParam(p.InfoHash)
+}
+
+func parseHashParam(s string) (session.InfoHash, error) {
+	hash, err := ParseHash(s)
+	if err != nil {
+		return hash, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return hash, nil
+}
+
+func (srv *Server) add(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p AddParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	hash, err := srv.s.AddTorrentData(p.Metainfo, session.AddOptions{Label: p.Label})
+	if err != nil {
+		return nil, err
+	}
+	return AddResult{InfoHash: hex.EncodeToString(hash[:])}, nil
+}
+
+// addMagnet returns once the link is parsed. The metadata is fetched in
+// the background and failures are logged.
+func (srv *Server) addMagnet(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p AddMagnetParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	link, err := magnet.Parse(p.URI)
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	if _, err := srv.s.Status(link.InfoHash); err == nil {
+		return nil, session.ErrExists
+	}
+	srv.s.Go(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, MagnetTimeout)
+		defer cancel()
+		_, err := srv.s.AddMagnet(ctx, p.URI, session.AddOptions{Label: p.Label})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			srv.s.Logger().Error("can't add magnet link", "err", err)
+		}
+	})
+	return AddResult{InfoHash: hex.EncodeToString(link.InfoHash[:])}, nil
+}
+
+func (srv *Server) list(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	list := []Torrent{}
+	for _, st := range srv.s.List() {
+		list = append(list, torrentOf(st))
+	}
+	return list, nil
+}
+
+func (srv *Server) status(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	hash, err := decodeHash(params)
+	if err != nil {
+		return nil, err
+	}
+	st, err := srv.s.Status(hash)
+	if err != nil {
+		return nil, err
+	}
+	return torrentOf(st), nil
+}
+
+func (srv *Server) pause(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	hash, err := decodeHash(params)
+	if err != nil {
+		return nil, err
+	}
+	return true, srv.s.Pause(hash)
+}
+
+func (srv *Server) resume(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	hash, err := decodeHash(params)
+	if err != nil {
+		return nil, err
+	}
+	return true, srv.s.Resume(hash)
+}
+
+func (srv *Server) remove(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p RemoveParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHashParam(p.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	return true, srv.s.Remove(hash, p.DeleteData)
+}
+
+func (srv *Server) setPriority(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p PriorityParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHashParam(p.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	prio, err := torrentmeta.ParsePriority(p.Priority)
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return true, srv.s.SetFilePriority(hash, p.File, prio)
+}
+
+func (srv *Server) setLimits(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p Limits
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHashParam(p.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	return true, srv.s.SetTorrentLimits(hash, p.Download, p.Upload)
+}
+
+func (srv *Server) peers(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	hash, err := decodeHash(params)
+	if err != nil {
+		return nil, err
+	}
+	list, err := srv.s.Peers(hash)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, len(list))
+	for i, p := range list {
+		peers[i] = Peer{Addr: p.Addr, Counters: p.Counters, DownloadRate: int(p.DownloadRate), UploadRate: int(p.UploadRate)}
+	}
+	return peers, nil
+}
+
+func (srv *Server) sessionLimits(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	down, up := srv.s.Limits()
+	return Limits{Download: down, Upload: up}, nil
+}
+
+func (srv *Server) sessionSetLimits(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p Limits
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	srv.s.SetLimits(p.Download, p.Upload)
+	return true, nil
+}
+
+func (srv *Server) sessionStats(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	t := srv.s.Totals()
+	return SessionStats{
+		Session:      t.Session,
+		AllTime:      t.AllTime,
+		DownloadRate: t.DownloadRate,
+		UploadRate:   t.UploadRate,
+		StartedAt:    t.StartedAt,
+	}, nil
+}
+
+func (srv *Server) setSeedLimits(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p SeedLimits
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHashParam(p.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := torrentmeta.ParseSeedAction(string(p.Action)); err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return true, srv.s.SetTorrentSeedLimits(hash, p.SeedLimits)
+}
+
+func (srv *Server) sessionSeedLimits(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	return SeedLimits{SeedLimits: srv.s.SeedLimits()}, nil
+}
+
+func (srv *Server) sessionSetSeedLimits(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p SeedLimits
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	if err := srv.s.SetSeedLimits(p.SeedLimits); err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return true, nil
+}
+
+func (srv *Server) queueMove(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p QueueMoveParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHashParam(p.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	move, err := session.ParseQueueMove(p.Move)
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return true, srv.s.MoveQueue(hash, move)
+}
+
+func (srv *Server) sessionQueue(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	return srv.s.Queue(), nil
+}
+
+func (srv *Server) sessionSetQueue(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p session.QueueConfig
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	srv.s.SetQueue(p)
+	return true, nil
+}
+
+func (srv *Server) setLabel(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p LabelParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	hash, err := parseHashParam(p.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	return true, srv.s.SetLabel(hash, p.Label)
+}
+
+func (srv *Server) categories(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	return srv.s.Categories(), nil
+}
+
+func (srv *Server) setCategory(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p CategoryParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	return true, srv.s.SetCategory(p.Name, p.Category)
+}
+
+func (srv *Server) removeCategory(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var p CategoryParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	return true, srv.s.RemoveCategory(p.Name)
+}
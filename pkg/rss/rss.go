@@ -0,0 +1,240 @@
+// Package rss reads RSS and Atom feeds of torrents and picks their items by
+// rules.
+package rss
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DanArmor/GoTorrent/pkg/magnet"
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+// MaxSize bounds the size of the feeds and torrent files that are fetched.
+const MaxSize = 10 << 20
+
+const torrentType = "application/x-bittorrent"
+
+// Item is a torrent of a feed.
+type Item struct {
+	// GUID identifies the item in its feed. It is the link when the feed
+	// gives none.
+	GUID  string
+	Title string
+	// URL is a magnet link or the address of a .torrent file.
+	URL string
+	// Size is the size of the content in bytes, 0 when unknown.
+	Size int64
+	// InfoHash is zero when the feed does not tell it.
+	InfoHash [utils.InfoHashLen]byte
+}
+
+// Magnet reports whether the URL of the item is a magnet link.
+func (it Item) Magnet() bool {
+	return strings.HasPrefix(it.URL, "magnet:")
+}
+
+type document struct {
+	XMLName xml.Name
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// RSS 1.0 puts the items next to the channel.
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title     string   `xml:"title"`
+	GUID      string   `xml:"guid"`
+	Links     []string `xml:"link"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	// Torznab and the torrent namespace give the size and the info hash.
+	Attrs []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"`
+	ContentLength int64  `xml:"contentLength"`
+	InfoHash      string `xml:"infoHash"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href   string `xml:"href,attr"`
+		Rel    string `xml:"rel,attr"`
+		Type   string `xml:"type,attr"`
+		Length int64  `xml:"length,attr"`
+	} `xml:"link"`
+}
+
+// Parse reads the items of an RSS or Atom feed. Items without a link are
+// left out.
+func Parse(data []byte) ([]Item, error) {
+	var doc document
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("malformed feed: %w", err)
+	}
+	switch doc.XMLName.Local {
+	case "rss", "RDF", "feed":
+	default:
+		return nil, fmt.Errorf("not a feed: <%s>", doc.XMLName.Local)
+	}
+	var items []Item
+	for _, ri := range append(doc.Channel.Items, doc.Items...) {
+		if it, ok := ri.item(); ok {
+			items = append(items, it)
+		}
+	}
+	for _, e := range doc.Entries {
+		if it, ok := e.item(); ok {
+			items = append(items, it)
+		}
+	}
+	return items, nil
+}
+
+func (ri rssItem) item() (Item, bool) {
+	it := Item{
+		GUID:  strings.TrimSpace(ri.GUID),
+		Title: strings.TrimSpace(ri.Title),
+		URL:   strings.TrimSpace(ri.Enclosure.URL),
+		Size:  ri.Enclosure.Length,
+	}
+	var hash, magnetURL string
+	for _, a := range ri.Attrs {
+		switch strings.ToLower(a.Name) {
+		case "size":
+			it.Size, _ = strconv.ParseInt(a.Value, 10, 64)
+		case "infohash":
+			hash = a.Value
+		case "magneturl":
+			magnetURL = a.Value
+		}
+	}
+	if ri.ContentLength > 0 {
+		it.Size = ri.ContentLength
+	}
+	if ri.InfoHash != "" {
+		hash = ri.InfoHash
+	}
+	if it.URL == "" {
+		for _, l := range ri.Links {
+			if l = strings.TrimSpace(l); l != "" {
+				it.URL = l
+				break
+			}
+		}
+	}
+	if it.URL == "" {
+		it.URL = magnetURL
+	}
+	return it.finish(hash)
+}
+
+func (e atomEntry) item() (Item, bool) {
+	it := Item{
+		GUID:  strings.TrimSpace(e.ID),
+		Title: strings.TrimSpace(e.Title),
+	}
+	// A torrent enclosure is preferred over a magnet link, which is
+	// preferred over the first link.
+	best := -1
+	for _, l := range e.Links {
+		rank := 0
+		switch {
+		case l.Rel == "enclosure" || l.Type == torrentType:
+			rank = 2
+		case strings.HasPrefix(l.Href, "magnet:"):
+			rank = 1
+		}
+		if l.Href != "" && rank > best {
+			best = rank
+			it.URL, it.Size = strings.TrimSpace(l.Href), l.Length
+		}
+	}
+	return it.finish("")
+}
+
+// finish fills in the GUID and the info hash of an item, from hash when it
+// is given in hex or else from the magnet link.
+func (it Item) finish(hash string) (Item, bool) {
+	if it.URL == "" {
+		return it, false
+	}
+	if it.GUID == "" {
+		it.GUID = it.URL
+	}
+	if buf, err := hex.DecodeString(strings.TrimSpace(hash)); err == nil && len(buf) == utils.InfoHashLen {
+		copy(it.InfoHash[:], buf)
+	} else if link, err := magnet.Parse(it.URL); err == nil {
+		it.InfoHash = link.InfoHash
+	}
+	return it, true
+}
+
+// Fetch downloads the data at link, which is at most MaxSize bytes.
+func Fetch(ctx context.Context, client *http.Client, link string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download %s: %s", link, resp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > MaxSize {
+		return nil, errors.New("download too large")
+	}
+	return buf, nil
+}
+
+// FetchFeed downloads and parses the feed at feedURL. Relative links of the
+// items are resolved against it.
+func FetchFeed(ctx context.Context, client *http.Client, feedURL string) ([]Item, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := Fetch(ctx, client, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	items, err := Parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if items[i].Magnet() {
+			continue
+		}
+		if u, err := base.Parse(items[i].URL); err == nil {
+			items[i].URL = u.String()
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,150 @@
+package rss
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+const (
+	hashHex    = "0123456789abcdef0123456789abcdef01234567"
+	magnetLink = "magnet:?xt=urn:btih:" + hashHex
+)
+
+const rss2Feed = `<?xml version="1.0"?>
+<rss version="2.0">
+<channel>
+	<title>feed</title>
+	<item>
+		<title> Show S01E02 </title>
+		<guid>item-1</guid>
+		<link>https://example.com/page/1</link>
+		<enclosure url="https://example.com/1.torrent" length="1000" type="application/x-bittorrent"/>
+	</item>
+	<item>
+		<title>Show S01E03</title>
+		<link>` + magnetLink + "&amp;dn=show" + `</link>
+	</item>
+	<item>
+		<title>No link</title>
+		<guid>item-3</guid>
+	</item>
+</channel>
+</rss>`
+
+const torznabFeed = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+<channel>
+	<item>
+		<title>Movie</title>
+		<guid>https://indexer/details/7</guid>
+		<link>https://indexer/download/7</link>
+		<torznab:attr name="size" value="123456"/>
+		<torznab:attr name="infohash" value="` + hashHex + `"/>
+	</item>
+	<item>
+		<title>Other</title>
+		<torznab:attr name="magneturl" value="` + magnetLink + `"/>
+	</item>
+</channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<entry>
+		<id>urn:entry:1</id>
+		<title>Album</title>
+		<link href="https://example.com/album"/>
+		<link rel="enclosure" type="application/x-bittorrent" href="/album.torrent" length="4096"/>
+		<link href="` + magnetLink + `"/>
+	</entry>
+	<entry>
+		<title>Magnet only</title>
+		<link href="` + magnetLink + `"/>
+	</entry>
+</feed>`
+
+func TestParse(t *testing.T) {
+	var h [utils.InfoHashLen]byte
+	hex.Decode(h[:], []byte(hashHex))
+	tests := []struct {
+		name string
+		feed string
+		want []Item
+	}{
+		{"rss 2.0", rss2Feed, []Item{
+			{GUID: "item-1", Title: "Show S01E02", URL: "https://example.com/1.torrent", Size: 1000},
+			{GUID: magnetLink + "&dn=show", Title: "Show S01E03", URL: magnetLink + "&dn=show", InfoHash: h},
+		}},
+		{"torznab", torznabFeed, []Item{
+			{GUID: "https://indexer/details/7", Title: "Movie", URL: "https://indexer/download/7", Size: 123456, InfoHash: h},
+			{GUID: magnetLink, Title: "Other", URL: magnetLink, InfoHash: h},
+		}},
+		{"atom", atomFeed, []Item{
+			{GUID: "urn:entry:1", Title: "Album", URL: "/album.torrent", Size: 4096},
+			{GUID: magnetLink, Title: "Magnet only", URL: magnetLink, InfoHash: h},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := Parse([]byte(tt.feed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != len(tt.want) {
+				t.Fatalf("got %d items, want %d: %+v", len(items), len(tt.want), items)
+			}
+			for i := range items {
+				if items[i] != tt.want[i] {
+					t.Errorf("item %d = %+v, want %+v", i, items[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, feed := range []string{"", "not xml", "<html><body/></html>"} {
+		if _, err := Parse([]byte(feed)); err == nil {
+			t.Errorf("Parse(%q) succeeded", feed)
+		}
+	}
+}
+
+func TestFetchFeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feeds/atom":
+			w.Write([]byte(atomFeed))
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", MaxSize+1)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	items, err := FetchFeed(context.Background(), srv.Client(), srv.URL+"/feeds/atom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	if want := srv.URL + "/album.torrent"; items[0].URL != want {
+		t.Errorf("relative link resolved to %q, want %q", items[0].URL, want)
+	}
+	if !items[1].Magnet() || items[1].URL != magnetLink {
+		t.Errorf("magnet link changed to %q", items[1].URL)
+	}
+	if _, err := FetchFeed(context.Background(), srv.Client(), srv.URL+"/missing"); err == nil {
+		t.Error("fetching a missing feed succeeded")
+	}
+	if _, err := Fetch(context.Background(), srv.Client(), srv.URL+"/big"); err == nil {
+		t.Error("fetching more than MaxSize succeeded")
+	}
+}
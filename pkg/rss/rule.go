@@ -0,0 +1,150 @@
+package rss
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Rule picks the items of feeds to download.
+type Rule struct {
+	Name string `json:"name"`
+	// Feeds are the URLs of the feeds the rule applies to, all of them
+	// when empty.
+	Feeds []string `json:"feeds,omitempty"`
+	// Include and Exclude are regular expressions matched against the
+	// title, ignoring case. An item must match Include, when set, and must
+	// not match Exclude.
+	Include string `json:"include,omitempty"`
+	Exclude string `json:"exclude,omitempty"`
+	// Episodes, when set, only lets through the episodes it lists, such as
+	// "1x2;1x5-8;2x10-;3x": an episode, a range, a season from an episode
+	// on and a whole season. Titles are read as S01E02 or 1x02.
+	Episodes string `json:"episodes,omitempty"`
+	// MinSize and MaxSize are in bytes. Zero means no limit. Items of
+	// unknown size are not limited.
+	MinSize int64 `json:"min_size,omitempty"`
+	MaxSize int64 `json:"max_size,omitempty"`
+	// Category and SavePath are given to the added torrents.
+	Category string `json:"category,omitempty"`
+	SavePath string `json:"save_path,omitempty"`
+	// Paused adds the torrents without starting them.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// episodeRange holds the episodes from first to last of a season. A last
+// of 0 means no end.
+type episodeRange struct {
+	season      int
+	first, last int
+}
+
+// Matcher is a compiled rule.
+type Matcher struct {
+	Rule
+	include, exclude *regexp.Regexp
+	episodes         []episodeRange
+}
+
+// Compile checks a rule and prepares it for matching.
+func (r Rule) Compile() (*Matcher, error) {
+	m := &Matcher{Rule: r}
+	var err error
+	if r.Include != "" {
+		if m.include, err = regexp.Compile("(?i)" + r.Include); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	if r.Exclude != "" {
+		if m.exclude, err = regexp.Compile("(?i)" + r.Exclude); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	if m.episodes, err = parseEpisodes(r.Episodes); err != nil {
+		return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	return m, nil
+}
+
+func parseEpisodes(filter string) ([]episodeRange, error) {
+	var ranges []episodeRange
+	for _, part := range strings.Split(filter, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		season, eps, ok := strings.Cut(strings.ToLower(part), "x")
+		var r episodeRange
+		var err error
+		if r.season, err = strconv.Atoi(season); !ok || err != nil || r.season < 0 {
+			return nil, fmt.Errorf("invalid episodes %q", part)
+		}
+		if eps != "" {
+			first, last, isRange := strings.Cut(eps, "-")
+			if r.first, err = strconv.Atoi(first); err != nil || r.first < 1 {
+				return nil, fmt.Errorf("invalid episodes %q", part)
+			}
+			r.last = r.first
+			if isRange {
+				r.last = 0
+				if last != "" {
+					if r.last, err = strconv.Atoi(last); err != nil || r.last < r.first {
+						return nil, fmt.Errorf("invalid episodes %q", part)
+					}
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+var episodeRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bS(\d{1,4})[ ._-]?E(\d{1,4})`),
+	regexp.MustCompile(`\b(\d{1,2})x(\d{1,4})\b`),
+}
+
+// Episode reads the season and episode numbers of a title.
+func Episode(title string) (season, episode int, ok bool) {
+	for _, re := range episodeRegexps {
+		if m := re.FindStringSubmatch(title); m != nil {
+			season, _ = strconv.Atoi(m[1])
+			episode, _ = strconv.Atoi(m[2])
+			return season, episode, true
+		}
+	}
+	return 0, 0, false
+}
+
+// AppliesTo reports whether the rule is for the feed at url.
+func (m *Matcher) AppliesTo(url string) bool {
+	return len(m.Feeds) == 0 || slices.Contains(m.Feeds, url)
+}
+
+// Match reports whether the rule picks an item.
+func (m *Matcher) Match(it Item) bool {
+	if m.include != nil && !m.include.MatchString(it.Title) {
+		return false
+	}
+	if m.exclude != nil && m.exclude.MatchString(it.Title) {
+		return false
+	}
+	if it.Size > 0 && (m.MinSize > 0 && it.Size < m.MinSize || m.MaxSize > 0 && it.Size > m.MaxSize) {
+		return false
+	}
+	if len(m.episodes) == 0 {
+		return true
+	}
+	season, episode, ok := Episode(it.Title)
+	if !ok {
+		return false
+	}
+	for _, r := range m.episodes {
+		if r.season == season && episode >= r.first && (r.last == 0 || episode <= r.last) {
+			return true
+		}
+	}
+	return false
+}
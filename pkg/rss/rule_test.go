@@ -0,0 +1,89 @@
+package rss
+
+import "testing"
+
+func TestCompileInvalid(t *testing.T) {
+	for _, r := range []Rule{
+		{Include: "("},
+		{Exclude: "[a-"},
+		{Episodes: "x2"},
+		{Episodes: "1x0"},
+		{Episodes: "1x5-3"},
+		{Episodes: "-1x2"},
+		{Episodes: "1"},
+	} {
+		if _, err := r.Compile(); err == nil {
+			t.Errorf("Compile(%+v) succeeded", r)
+		}
+	}
+}
+
+func TestEpisode(t *testing.T) {
+	tests := []struct {
+		title           string
+		season, episode int
+		ok              bool
+	}{
+		{"Show.S01E02.720p", 1, 2, true},
+		{"Show s2 e10", 2, 10, true},
+		{"Show S03.E04", 3, 4, true},
+		{"Show 4x07 HDTV", 4, 7, true},
+		{"Show 2024 1080p", 0, 0, false},
+	}
+	for _, tt := range tests {
+		season, episode, ok := Episode(tt.title)
+		if season != tt.season || episode != tt.episode || ok != tt.ok {
+			t.Errorf("Episode(%q) = %d, %d, %v, want %d, %d, %v", tt.title, season, episode, ok, tt.season, tt.episode, tt.ok)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		item  Item
+		match bool
+	}{
+		{"empty rule", Rule{}, Item{Title: "Anything"}, true},
+		{"include", Rule{Include: `show\b`}, Item{Title: "The SHOW S01E01"}, true},
+		{"include misses", Rule{Include: "show"}, Item{Title: "Movie"}, false},
+		{"exclude", Rule{Include: "show", Exclude: "720p|cam"}, Item{Title: "Show S01E01 720p"}, false},
+		{"exclude misses", Rule{Include: "show", Exclude: "720p"}, Item{Title: "Show S01E01 1080p"}, true},
+		{"episode", Rule{Episodes: "1x2"}, Item{Title: "Show S01E02"}, true},
+		{"other episode", Rule{Episodes: "1x2"}, Item{Title: "Show S01E03"}, false},
+		{"range", Rule{Episodes: "1x5-8"}, Item{Title: "Show 1x08"}, true},
+		{"past range", Rule{Episodes: "1x5-8"}, Item{Title: "Show 1x09"}, false},
+		{"open range", Rule{Episodes: "2x10-"}, Item{Title: "Show S02E99"}, true},
+		{"before open range", Rule{Episodes: "2x10-"}, Item{Title: "Show S02E09"}, false},
+		{"season", Rule{Episodes: "1x2;3x"}, Item{Title: "Show S03E01"}, true},
+		{"other season", Rule{Episodes: "3x"}, Item{Title: "Show S04E01"}, false},
+		{"no episode", Rule{Episodes: "1x"}, Item{Title: "Show special"}, false},
+		{"min size", Rule{MinSize: 100}, Item{Size: 99}, false},
+		{"max size", Rule{MaxSize: 100}, Item{Size: 101}, false},
+		{"within sizes", Rule{MinSize: 100, MaxSize: 100}, Item{Size: 100}, true},
+		{"unknown size", Rule{MinSize: 100, MaxSize: 200}, Item{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.rule.Compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Match(tt.item); got != tt.match {
+				t.Errorf("Match(%+v) = %v, want %v", tt.item, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestAppliesTo(t *testing.T) {
+	all, _ := Rule{}.Compile()
+	one, _ := Rule{Feeds: []string{"https://a/feed"}}.Compile()
+	if !all.AppliesTo("https://b/feed") {
+		t.Error("rule without feeds does not apply to a feed")
+	}
+	if !one.AppliesTo("https://a/feed") || one.AppliesTo("https://b/feed") {
+		t.Error("rule with feeds applies to the wrong feeds")
+	}
+}
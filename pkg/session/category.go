@@ -0,0 +1,143 @@
+package session
+
+import (
+	"errors"
+	"maps"
+
+	"github.com/DanArmor/GoTorrent/pkg/ratelimit"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// Category holds the settings of the torrents labelled with its name.
+type Category struct {
+	// SavePath replaces the download directory of the session for the
+	// torrents added to the category.
+	SavePath string `json:"save_path,omitempty"`
+	// DownloadLimit and UploadLimit are in bytes per second for all
+	// torrents of the category together. Zero means no limit.
+	DownloadLimit int `json:"download_limit,omitempty"`
+	UploadLimit   int `json:"upload_limit,omitempty"`
+	// Seeding are the seeding limits of the category over those of the
+	// session, with the same meaning as the limits of a torrent.
+	Seeding torrentmeta.SeedLimits `json:"seeding"`
+}
+
+type categoryLimiters struct {
+	down *ratelimit.Limiter
+	up   *ratelimit.Limiter
+}
+
+func (s *Session) initCategories() {
+	s.catLimits = make(map[string]categoryLimiters)
+	for name, c := range s.cfg.Categories {
+		s.catLimits[name] = categoryLimiters{down: ratelimit.New(c.DownloadLimit), up: ratelimit.New(c.UploadLimit)}
+	}
+}
+
+// Categories returns the categories of the session by name.
+func (s *Session) Categories() map[string]Category {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.cfg.Categories)
+}
+
+// SetCategory creates or changes a category and saves it to the
+// configuration file. Torrents that run take a new speed limit at once and a
+// new save path when they are added.
+func (s *Session) SetCategory(name string, c Category) error {
+	if name == "" {
+		return errors.New("category has no name")
+	}
+	if _, err := torrentmeta.ParseSeedAction(string(c.Seeding.Action)); err != nil {
+		return err
+	}
+	s.catMu.Lock()
+	defer s.catMu.Unlock()
+	s.mu.Lock()
+	cats := maps.Clone(s.cfg.Categories)
+	if cats == nil {
+		cats = make(map[string]Category)
+	}
+	cats[name] = c
+	s.cfg.Categories = cats
+	if l, ok := s.catLimits[name]; ok {
+		l.down.SetRate(c.DownloadLimit)
+		l.up.SetRate(c.UploadLimit)
+	} else {
+		s.catLimits[name] = categoryLimiters{down: ratelimit.New(c.DownloadLimit), up: ratelimit.New(c.UploadLimit)}
+	}
+	s.mu.Unlock()
+	return s.saveCategories(cats)
+}
+
+// RemoveCategory removes a category. Its torrents keep their label.
+func (s *Session) RemoveCategory(name string) error {
+	s.catMu.Lock()
+	defer s.catMu.Unlock()
+	s.mu.Lock()
+	if _, ok := s.cfg.Categories[name]; !ok {
+		s.mu.Unlock()
+		return errors.New("no such category")
+	}
+	cats := maps.Clone(s.cfg.Categories)
+	delete(cats, name)
+	s.cfg.Categories = cats
+	if l, ok := s.catLimits[name]; ok {
+		l.down.SetRate(0)
+		l.up.SetRate(0)
+	}
+	s.mu.Unlock()
+	return s.saveCategories(cats)
+}
+
+// saveCategories writes the categories to the configuration file, keeping
+// the rest of it as it is on disk. s.catMu must be held.
+func (s *Session) saveCategories(cats map[string]Category) error {
+	cfg, err := LoadConfig(s.cfg.ConfigPath)
+	if err != nil {
+		return err
+	}
+	cfg.Categories = cats
+	return cfg.Save()
+}
+
+// SetLabel puts a torrent in a category, or in none with an empty label.
+// Running torrents take the speed limits of the category when they are
+// started again.
+func (s *Session) SetLabel(hash InfoHash, label string) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	h.tf.Label = label
+	s.mu.Unlock()
+	s.save(h.tf)
+	return nil
+}
+
+// limitersOf returns the speed limiters of the category of a torrent, nil
+// when it has none. s.mu must be held.
+func (s *Session) limitersOf(label string) categoryLimiters {
+	return s.catLimits[label]
+}
+
+// seedLimitsOf returns the seeding limits the limits of a torrent in the
+// category apply over. s.mu must be held.
+func (s *Session) seedLimitsOf(label string) torrentmeta.SeedLimits {
+	if c, ok := s.cfg.Categories[label]; ok {
+		return c.Seeding.Over(s.cfg.Seeding)
+	}
+	return s.cfg.Seeding
+}
+
+// savePath returns where the torrents of the category are stored once
+// complete.
+func (s *Session) savePath(label string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.cfg.Categories[label]; ok && c.SavePath != "" {
+		return c.SavePath
+	}
+	return s.cfg.DownloadPath
+}
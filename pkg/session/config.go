@@ -0,0 +1,203 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/DanArmor/GoTorrent/pkg/diskio"
+	"github.com/DanArmor/GoTorrent/pkg/rss"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+const configName = "config.json"
+
+const defaultLogMaxSize = 10 << 20
+
+type Config struct {
+	// ConfigPath is the directory holding the configuration and the state
+	// of the torrents.
+	ConfigPath   string `json:"-"`
+	DownloadPath string `json:"download_path"`
+	// IncompletePath, when set, holds torrents until they are completed.
+	IncompletePath string `json:"incomplete_path,omitempty"`
+	// CompletedPath, when set, is where completed torrents are moved to.
+	CompletedPath string             `json:"completed_path,omitempty"`
+	ListenPort    uint16             `json:"listen_port"`
+	Allocation    storage.Allocation `json:"allocation"`
+	Mmap          bool               `json:"mmap,omitempty"`
+	Disk          diskio.Config      `json:"disk"`
+	// DownloadLimit and UploadLimit are in bytes per second for all
+	// torrents together. Zero means no limit.
+	DownloadLimit int        `json:"download_limit,omitempty"`
+	UploadLimit   int        `json:"upload_limit,omitempty"`
+	LogLevel      slog.Level `json:"log_level"`
+	// LogFile, when set, receives the log as JSON lines. It is rotated
+	// once it grows past LogMaxSize bytes, keeping LogMaxBackups old files.
+	LogFile       string `json:"log_file,omitempty"`
+	LogMaxSize    int64  `json:"log_max_size,omitempty"`
+	LogMaxBackups int    `json:"log_max_backups,omitempty"`
+	// LogWriter, when set, receives the log as text.
+	LogWriter io.Writer `json:"-"`
+	// Daemon configures the control API of the daemon mode.
+	Daemon DaemonConfig `json:"daemon"`
+	// Queue limits how many torrents run at once.
+	Queue QueueConfig `json:"queue"`
+	// Categories are the settings of the torrents by label.
+	Categories map[string]Category `json:"categories,omitempty"`
+	// Watch are folders torrent and magnet files are added from.
+	Watch []WatchFolder `json:"watch,omitempty"`
+	// RSS are feeds torrents are downloaded from by rules.
+	RSS RSSConfig `json:"rss"`
+	// Seeding are the seeding limits of torrents that have none of their
+	// own.
+	Seeding torrentmeta.SeedLimits `json:"seeding"`
+	// Storage replaces the storage backend chosen by Allocation and Mmap.
+	Storage storage.Opener `json:"-"`
+	// HTTPClient fetches the feeds and the torrent files they link to. It
+	// defaults to a client with a timeout of 30 seconds.
+	HTTPClient *http.Client `json:"-"`
+}
+
+type DaemonConfig struct {
+	// Socket is the path of the Unix socket of the API. It defaults to
+	// daemon.sock in ConfigPath.
+	Socket string `json:"socket,omitempty"`
+	// Listen, when set, is a TCP address the API is served on as well.
+	// Requests on it must carry Token.
+	Listen string `json:"listen,omitempty"`
+	Token  string `json:"token,omitempty"`
+	// Transmission serves the Transmission RPC protocol at
+	// /transmission/rpc next to the API.
+	Transmission bool `json:"transmission,omitempty"`
+	// Metrics, when set, is a TCP address Prometheus metrics are served on
+	// at /metrics, without authentication.
+	Metrics string `json:"metrics,omitempty"`
+}
+
+// QueueConfig limits the torrents running at once. Zero means no limit.
+// With IgnoreSlow, torrents that have run for a while below the slow rates,
+// in bytes per second, do not count toward the limits.
+type QueueConfig struct {
+	MaxDownloads     int  `json:"max_downloads"`
+	MaxSeeds         int  `json:"max_seeds"`
+	MaxActive        int  `json:"max_active"`
+	IgnoreSlow       bool `json:"ignore_slow,omitempty"`
+	SlowDownloadRate int  `json:"slow_download_rate,omitempty"`
+	SlowUploadRate   int  `json:"slow_upload_rate,omitempty"`
+}
+
+// WatchFolder is a folder new .torrent and .magnet files are added from.
+// Added files are renamed with an .added suffix, or moved to MoveTo when it
+// is set. Files that cannot be parsed get an .invalid suffix, while those
+// that fail for another reason are tried again later.
+type WatchFolder struct {
+	Path string `json:"path"`
+	// SavePath replaces the download directory of the session.
+	SavePath string `json:"save_path,omitempty"`
+	Label    string `json:"label,omitempty"`
+	MoveTo   string `json:"move_to,omitempty"`
+	// Paused adds the torrents without starting them.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// RSSConfig lists the feeds to read and the rules picking their items. An
+// item is added once, by the first rule that picks it, unless the session
+// already has its torrent.
+type RSSConfig struct {
+	Feeds []Feed     `json:"feeds,omitempty"`
+	Rules []rss.Rule `json:"rules,omitempty"`
+}
+
+type Feed struct {
+	URL string `json:"url"`
+	// Interval is how often the feed is read, in minutes. It defaults to
+	// 15.
+	Interval int `json:"interval,omitempty"`
+}
+
+// SocketPath returns the path of the Unix socket of the daemon.
+func (c *Config) SocketPath() string {
+	if c.Daemon.Socket != "" {
+		return c.Daemon.Socket
+	}
+	return filepath.Join(c.ConfigPath, "daemon.sock")
+}
+
+// DefaultConfig keeps the state in the user config directory and downloads
+// to ~/Downloads.
+func DefaultConfig() (Config, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return Config{}, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		ConfigPath:   filepath.Join(dir, ".gotorrent"),
+		DownloadPath: filepath.Join(home, "Downloads"),
+		ListenPort:   torrentmeta.Port,
+		Allocation:   storage.AllocateSparse,
+		LogLevel:     slog.LevelInfo,
+		LogMaxSize:   defaultLogMaxSize,
+		Queue: QueueConfig{
+			MaxDownloads:     5,
+			MaxSeeds:         5,
+			SlowDownloadRate: 2000,
+			SlowUploadRate:   2000,
+		},
+	}, nil
+}
+
+// LoadConfig reads config.json from dir over the defaults. A missing file is
+// not an error.
+func LoadConfig(dir string) (Config, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ConfigPath = dir
+	buf, err := os.ReadFile(filepath.Join(dir, configName))
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Save writes the configuration to config.json in ConfigPath.
+func (c *Config) Save() error {
+	buf, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.ConfigPath, 0770); err != nil {
+		return err
+	}
+	return storage.WriteFile(filepath.Join(c.ConfigPath, configName), append(buf, '\n'), 0644)
+}
+
+func (c *Config) opener() storage.Opener {
+	if c.Storage != nil {
+		return c.Storage
+	}
+	if c.Mmap {
+		return storage.NewMmap(storage.MmapOptions{
+			FlushInterval: mmapFlushInterval,
+			Allocation:    c.Allocation,
+		})
+	}
+	return storage.FileOpener(c.Allocation)
+}
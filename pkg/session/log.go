@@ -0,0 +1,64 @@
+package session
+
+import (
+	"log/slog"
+
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/logging"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// openLog sets up the loggers of the session. Every record goes to the ring
+// shown by the TUI and, when configured, to LogWriter and a rotated JSON
+// file.
+func (s *Session) openLog() error {
+	s.level.Set(s.cfg.LogLevel)
+	s.ring = logging.NewRing(0, &s.level, func(level slog.Level, line string) {
+		s.events.Publish(event.Event{Type: event.LogMessage, Message: line})
+	})
+	handlers := []slog.Handler{s.ring}
+	if s.cfg.LogWriter != nil {
+		handlers = append(handlers, slog.NewTextHandler(s.cfg.LogWriter, &slog.HandlerOptions{Level: &s.level}))
+	}
+	if s.cfg.LogFile != "" {
+		f, err := logging.OpenRotating(s.cfg.LogFile, s.cfg.LogMaxSize, s.cfg.LogMaxBackups)
+		if err != nil {
+			return err
+		}
+		s.logFile = f
+		handlers = append(handlers, slog.NewJSONHandler(f, &slog.HandlerOptions{Level: &s.level}))
+	}
+	s.root = slog.New(logging.Multi(handlers...))
+	s.log = s.root.With(logging.Component("session"))
+	return nil
+}
+
+func (s *Session) closeLog() {
+	if s.logFile != nil {
+		s.logFile.Close()
+	}
+}
+
+// torrentLog returns the logger of a component working on tf.
+func (s *Session) torrentLog(component string, tf *torrentmeta.TorrentFile) *slog.Logger {
+	return s.root.With(logging.Component(component), logging.InfoHash(tf.InfoHash), slog.String("name", tf.Name))
+}
+
+// Logger returns the logger of the session.
+func (s *Session) Logger() *slog.Logger {
+	return s.log
+}
+
+// LogLines returns the last lines logged, oldest first.
+func (s *Session) LogLines() []string {
+	return s.ring.Lines()
+}
+
+func (s *Session) LogLevel() slog.Level {
+	return s.level.Level()
+}
+
+// SetLogLevel changes the level of all loggers of the session at runtime.
+func (s *Session) SetLogLevel(level slog.Level) {
+	s.level.Set(level)
+}
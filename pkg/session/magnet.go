@@ -0,0 +1,68 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/DanArmor/GoTorrent/pkg/logging"
+	"github.com/DanArmor/GoTorrent/pkg/magnet"
+	"github.com/DanArmor/GoTorrent/pkg/metadata"
+	"github.com/DanArmor/GoTorrent/pkg/torrent"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// AddMagnet fetches the metadata of a magnet link from the peers of its
+// trackers and adds the torrent. It blocks until the metadata is received or
+// ctx is done.
+func (s *Session) AddMagnet(ctx context.Context, uri string, opts AddOptions) (InfoHash, error) {
+	link, err := magnet.Parse(uri)
+	if err != nil {
+		return InfoHash{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if _, err := s.find(link.InfoHash); err == nil {
+		return link.InfoHash, ErrExists
+	}
+	if len(link.Trackers) == 0 {
+		return link.InfoHash, fmt.Errorf("%w: magnet link has no trackers", ErrInvalid)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	log := s.root.With(logging.Component("magnet"), logging.InfoHash(link.InfoHash))
+	var info []byte
+	var announce string
+	for _, tracker := range link.Trackers {
+		tf := torrentmeta.TorrentFile{TorrentFile: torrent.TorrentFile{Announce: tracker, InfoHash: link.InfoHash}}
+		ps, err := tf.RequestPeers(s.peerID, s.cfg.ListenPort)
+		if err != nil {
+			log.Warn("tracker failed", "tracker", tracker, "err", err)
+			continue
+		}
+		info, err = metadata.Fetch(ctx, ps, link.InfoHash, s.peerID)
+		if err == nil {
+			announce = tracker
+			break
+		}
+		if ctx.Err() != nil {
+			return link.InfoHash, ctx.Err()
+		}
+		log.Warn("no metadata from peers", "tracker", tracker, "err", err)
+	}
+	if info == nil {
+		return link.InfoHash, fmt.Errorf("could not fetch metadata of %s", hex.EncodeToString(link.InfoHash[:]))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "d8:announce%d:%s4:info", len(announce), announce)
+	buf.Write(info)
+	buf.WriteString("e")
+	return s.AddTorrentData(buf.Bytes(), opts)
+}
@@ -0,0 +1,36 @@
+package session
+
+import (
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
+)
+
+var peersConnected = metrics.Default.GaugeVec("gotorrent_peers_connected", "Connected peers by how they were found.", "info_hash", "source")
+
+// registerMetrics adds the gauges read from the session to the default
+// registry, replacing those of an earlier session.
+func (s *Session) registerMetrics() {
+	metrics.Default.GaugeFunc("gotorrent_disk_queue_depth", "Writes waiting for a disk worker.", nil, func(observe func(float64, ...string)) {
+		observe(float64(s.disk.QueueLen()))
+	})
+	metrics.Default.GaugeFunc("gotorrent_torrents", "Torrents by state.", []string{"state"}, func(observe func(float64, ...string)) {
+		count := make(map[State]int)
+		for _, st := range s.List() {
+			count[st.State]++
+		}
+		for state, n := range count {
+			observe(float64(n), string(state))
+		}
+	})
+	metrics.Default.GaugeFunc("gotorrent_torrent_progress", "Fraction of the wanted pieces that are verified.", []string{"info_hash"}, func(observe func(float64, ...string)) {
+		for _, tf := range s.Torrents() {
+			observe(tf.Progress(), metrics.InfoHash(tf.InfoHash))
+		}
+	})
+	metrics.Default.GaugeFunc("gotorrent_torrent_verification_progress", "Progress of running rechecks.", []string{"info_hash"}, func(observe func(float64, ...string)) {
+		for _, tf := range s.Torrents() {
+			if checking, progress := tf.Checking(); checking {
+				observe(progress, metrics.InfoHash(tf.InfoHash))
+			}
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// slowGrace is how long a torrent runs before it can count as slow.
+const slowGrace = time.Minute
+
+type QueueMove string
+
+const (
+	QueueUp     QueueMove = "up"
+	QueueDown   QueueMove = "down"
+	QueueTop    QueueMove = "top"
+	QueueBottom QueueMove = "bottom"
+)
+
+func ParseQueueMove(s string) (QueueMove, error) {
+	switch m := QueueMove(s); m {
+	case QueueUp, QueueDown, QueueTop, QueueBottom:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown queue move %q", s)
+}
+
+func (s *Session) Queue() QueueConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.Queue
+}
+
+// SetQueue changes the limits of the queue for this run. Torrents over
+// lowered limits keep running.
+func (s *Session) SetQueue(q QueueConfig) {
+	s.mu.Lock()
+	s.cfg.Queue = q
+	s.mu.Unlock()
+	s.schedule()
+}
+
+// MoveQueue moves a torrent in the queue. Queued torrents start in queue
+// order.
+func (s *Session) MoveQueue(hash InfoHash, move QueueMove) error {
+	s.mu.Lock()
+	i := -1
+	for j, h := range s.torrents {
+		if h.tf.InfoHash == hash {
+			i = j
+		}
+	}
+	if i < 0 {
+		s.mu.Unlock()
+		return ErrNotFound
+	}
+	to := i
+	switch move {
+	case QueueUp:
+		to = max(i-1, 0)
+	case QueueDown:
+		to = min(i+1, len(s.torrents)-1)
+	case QueueTop:
+		to = 0
+	case QueueBottom:
+		to = len(s.torrents) - 1
+	default:
+		s.mu.Unlock()
+		return fmt.Errorf("unknown queue move %q", move)
+	}
+	h := s.torrents[i]
+	s.torrents = append(s.torrents[:i], s.torrents[i+1:]...)
+	s.torrents = append(s.torrents[:to], append([]*handle{h}, s.torrents[to:]...)...)
+	changed := s.renumber()
+	s.mu.Unlock()
+	for _, tf := range changed {
+		s.save(tf)
+	}
+	s.schedule()
+	return nil
+}
+
+// renumber sets the queue positions to the order of the torrents and
+// returns those whose position changed. s.mu must be held.
+func (s *Session) renumber() []*torrentmeta.TorrentFile {
+	var changed []*torrentmeta.TorrentFile
+	for i, h := range s.torrents {
+		if h.tf.QueuePosition != i {
+			h.tf.QueuePosition = i
+			changed = append(changed, h.tf)
+		}
+	}
+	return changed
+}
+
+// enqueue queues the torrent to be started once the queue has room for it.
+func (s *Session) enqueue(h *handle) {
+	s.mu.Lock()
+	if !h.tf.InProgress {
+		h.tf.Queued = true
+	}
+	s.mu.Unlock()
+	s.schedule()
+}
+
+// slow reports whether a running torrent does not count toward the limits
+// of the queue. s.mu must be held.
+func (s *Session) slow(h *handle, now time.Time) bool {
+	q := s.cfg.Queue
+	if !q.IgnoreSlow || now.Sub(h.startedAt) < slowGrace {
+		return false
+	}
+	down, up := h.tf.Stats().Rates()
+	if h.tf.IsDone {
+		return int(up) < q.SlowUploadRate
+	}
+	return int(down) < q.SlowDownloadRate
+}
+
+// schedule starts the queued torrents, in queue order, while the limits of
+// the queue allow.
+func (s *Session) schedule() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.mu.Lock()
+	next := s.dequeue(time.Now())
+	s.mu.Unlock()
+	for _, h := range next {
+		s.start(h)
+	}
+}
+
+// dequeue takes the queued torrents the limits of the queue let start out of
+// the queue, in queue order. s.mu must be held.
+func (s *Session) dequeue(now time.Time) []*handle {
+	q := s.cfg.Queue
+	var downloads, seeds int
+	for _, h := range s.torrents {
+		if h.tf.InProgress && !s.slow(h, now) {
+			if h.tf.IsDone {
+				seeds++
+			} else {
+				downloads++
+			}
+		}
+	}
+	var next []*handle
+	for _, h := range s.torrents {
+		if !h.tf.Queued || h.tf.Moving() {
+			continue
+		}
+		if h.cancelCheck != nil {
+			continue
+		}
+		if q.MaxActive > 0 && downloads+seeds >= q.MaxActive {
+			break
+		}
+		if h.tf.IsDone {
+			if q.MaxSeeds > 0 && seeds >= q.MaxSeeds {
+				continue
+			}
+			seeds++
+		} else {
+			if q.MaxDownloads > 0 && downloads >= q.MaxDownloads {
+				continue
+			}
+			downloads++
+		}
+		h.tf.Queued = false
+		next = append(next, h)
+	}
+	return next
+}
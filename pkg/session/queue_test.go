@@ -0,0 +1,98 @@
+package session
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// queueState is the state of a torrent in the tests of the queue.
+type queueState int
+
+const (
+	queuedDownload queueState = iota
+	queuedSeed
+	runningDownload
+	runningSeed
+	slowDownload
+	checkingDownload
+	stopped
+)
+
+func TestDequeue(t *testing.T) {
+	tests := []struct {
+		name     string
+		queue    QueueConfig
+		torrents []queueState
+		want     []int
+	}{
+		{"no limits", QueueConfig{}, []queueState{queuedDownload, runningDownload, queuedSeed, stopped}, []int{0, 2}},
+		{"nothing queued", QueueConfig{MaxActive: 5}, []queueState{runningSeed, stopped}, nil},
+		{"downloads full", QueueConfig{MaxDownloads: 1}, []queueState{runningDownload, queuedDownload, queuedSeed}, []int{2}},
+		{"downloads in queue order", QueueConfig{MaxDownloads: 2}, []queueState{queuedDownload, runningDownload, queuedDownload, queuedDownload}, []int{0}},
+		{"seeds full", QueueConfig{MaxSeeds: 1}, []queueState{queuedSeed, queuedSeed, queuedDownload}, []int{0, 2}},
+		{"active full", QueueConfig{MaxActive: 1}, []queueState{runningSeed, queuedDownload, queuedSeed}, nil},
+		{"active stops at the limit", QueueConfig{MaxActive: 2}, []queueState{queuedSeed, runningDownload, queuedDownload, queuedSeed}, []int{0}},
+		{"active and downloads", QueueConfig{MaxActive: 3, MaxDownloads: 1}, []queueState{queuedDownload, queuedDownload, queuedSeed, queuedSeed, queuedSeed}, []int{0, 2, 3}},
+		{"checking", QueueConfig{}, []queueState{checkingDownload, queuedDownload}, []int{1}},
+		{"slow counted", QueueConfig{MaxDownloads: 1}, []queueState{slowDownload, queuedDownload}, nil},
+		{"slow ignored", QueueConfig{MaxDownloads: 1, IgnoreSlow: true, SlowDownloadRate: 1024}, []queueState{slowDownload, queuedDownload}, []int{1}},
+	}
+	s := testSession(t, Config{})
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var torrents []*handle
+			for i, state := range tt.torrents {
+				tf, err := torrentmeta.NewBytes(testMetainfo(string(rune('a'+i))), t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				h := s.newHandle(tf)
+				h.startedAt = now
+				switch state {
+				case queuedDownload:
+					tf.Queued = true
+				case queuedSeed:
+					tf.Queued, tf.IsDone = true, true
+				case runningDownload:
+					tf.InProgress = true
+				case runningSeed:
+					tf.InProgress, tf.IsDone = true, true
+				case slowDownload:
+					tf.InProgress = true
+					h.startedAt = now.Add(-2 * slowGrace)
+				case checkingDownload:
+					tf.Queued = true
+					_, h.cancelCheck = context.WithCancel(context.Background())
+				}
+				torrents = append(torrents, h)
+			}
+			s.mu.Lock()
+			s.cfg.Queue = tt.queue
+			s.torrents = torrents
+			next := s.dequeue(now)
+			s.torrents = nil
+			s.mu.Unlock()
+			var got []int
+			for _, h := range next {
+				i := -1
+				for j := range torrents {
+					if torrents[j] == h {
+						i = j
+					}
+				}
+				if h.tf.Queued {
+					t.Errorf("torrent %d is still queued", i)
+				}
+				got = append(got, i)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dequeue started %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package session
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/rss"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
+)
+
+const rssHistoryName = "rss.json"
+
+const (
+	// defaultFeedInterval is how often feeds are read unless they say
+	// otherwise.
+	defaultFeedInterval = 15 * time.Minute
+	// feedTimeout bounds the requests of the default HTTP client.
+	feedTimeout = 30 * time.Second
+	// rssHistoryAge is how long items are remembered after they left
+	// their feed.
+	rssHistoryAge = 90 * 24 * time.Hour
+)
+
+// rssHistory holds when the added items were last seen in their feeds, by
+// feed URL and GUID, and by info hash in hex.
+type rssHistory struct {
+	GUIDs      map[string]time.Time `json:"guids"`
+	InfoHashes map[string]time.Time `json:"info_hashes"`
+}
+
+type feedReader struct {
+	s      *Session
+	client *http.Client
+	rules  []*rss.Matcher
+	mu     sync.Mutex
+	hist   rssHistory
+}
+
+// readFeeds reads the feeds and adds the items picked by the rules until
+// ctx is done.
+func (s *Session) readFeeds(ctx context.Context) {
+	if len(s.cfg.RSS.Feeds) == 0 {
+		return
+	}
+	r := s.newFeedReader()
+	for _, f := range s.cfg.RSS.Feeds {
+		f := f
+		interval := time.Duration(f.Interval) * time.Minute
+		if interval <= 0 {
+			interval = defaultFeedInterval
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				r.read(ctx, f.URL)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+			}
+		}()
+		s.log.Info("reading feed", "url", f.URL, "interval", interval)
+	}
+}
+
+// newFeedReader compiles the rules and loads the history of the feeds.
+func (s *Session) newFeedReader() *feedReader {
+	r := &feedReader{s: s, client: s.cfg.HTTPClient}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: feedTimeout}
+	}
+	for _, rule := range s.cfg.RSS.Rules {
+		m, err := rule.Compile()
+		if err != nil {
+			s.log.Error("invalid RSS rule", "err", err)
+			continue
+		}
+		r.rules = append(r.rules, m)
+	}
+	if err := r.load(); err != nil {
+		s.log.Error("could not load RSS history", "err", err)
+	}
+	return r
+}
+
+func (r *feedReader) path() string {
+	return filepath.Join(r.s.cfg.ConfigPath, rssHistoryName)
+}
+
+func (r *feedReader) load() error {
+	r.hist = rssHistory{GUIDs: make(map[string]time.Time), InfoHashes: make(map[string]time.Time)}
+	buf, err := os.ReadFile(r.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		err = json.Unmarshal(buf, &r.hist)
+	}
+	if r.hist.GUIDs == nil {
+		r.hist.GUIDs = make(map[string]time.Time)
+	}
+	if r.hist.InfoHashes == nil {
+		r.hist.InfoHashes = make(map[string]time.Time)
+	}
+	return err
+}
+
+// save writes the history, forgetting the items that have not been seen
+// for a long time. r.mu must be held.
+func (r *feedReader) save(now time.Time) {
+	for _, m := range []map[string]time.Time{r.hist.GUIDs, r.hist.InfoHashes} {
+		for k, seen := range m {
+			if now.Sub(seen) > rssHistoryAge {
+				delete(m, k)
+			}
+		}
+	}
+	buf, err := json.MarshalIndent(r.hist, "", "\t")
+	if err == nil {
+		err = storage.WriteFile(r.path(), append(buf, '\n'), 0644)
+	}
+	if err != nil {
+		r.s.log.Error("could not save RSS history", "err", err)
+	}
+}
+
+func guidKey(feed string, it rss.Item) string {
+	return feed + " " + it.GUID
+}
+
+// seen reports whether the item was added already, from this feed or
+// another one, or the session has its torrent. It notes that the item is
+// still in its feed.
+func (r *feedReader) seen(feed string, it rss.Item, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := guidKey(feed, it)
+	if _, ok := r.hist.GUIDs[key]; ok {
+		r.hist.GUIDs[key] = now
+		return true
+	}
+	if it.InfoHash == (InfoHash{}) {
+		return false
+	}
+	hash := hex.EncodeToString(it.InfoHash[:])
+	_, ok := r.hist.InfoHashes[hash]
+	if _, err := r.s.find(it.InfoHash); err == nil {
+		ok = true
+	}
+	if ok {
+		r.hist.GUIDs[key] = now
+		r.hist.InfoHashes[hash] = now
+	}
+	return ok
+}
+
+// remember records an added item, or forgets it when added is false.
+func (r *feedReader) remember(feed string, it rss.Item, hash InfoHash, added bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	key, hexHash := guidKey(feed, it), hex.EncodeToString(hash[:])
+	if added {
+		r.hist.GUIDs[key] = now
+		if hash != (InfoHash{}) {
+			r.hist.InfoHashes[hexHash] = now
+		}
+	} else {
+		delete(r.hist.GUIDs, key)
+		delete(r.hist.InfoHashes, hexHash)
+	}
+	r.save(now)
+}
+
+// read reads a feed once and adds its new items picked by a rule.
+func (r *feedReader) read(ctx context.Context, feed string) {
+	log := r.s.log.With("feed", feed)
+	items, err := rss.FetchFeed(ctx, r.client, feed)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn("could not read feed", "err", err)
+		}
+		return
+	}
+	log.Debug("read feed", "items", len(items))
+	now := time.Now()
+	for _, it := range items {
+		if ctx.Err() != nil {
+			return
+		}
+		if r.seen(feed, it, now) {
+			continue
+		}
+		for _, m := range r.rules {
+			if m.AppliesTo(feed) && m.Match(it) {
+				r.add(ctx, log.With("rule", m.Name, "title", it.Title), feed, it, m)
+				break
+			}
+		}
+	}
+	r.mu.Lock()
+	r.save(now)
+	r.mu.Unlock()
+}
+
+// add hands an item to the session. Torrent files that cannot be added are
+// tried again on the next read of the feed.
+func (r *feedReader) add(ctx context.Context, log *slog.Logger, feed string, it rss.Item, m *rss.Matcher) {
+	opts := AddOptions{SavePath: m.SavePath, Label: m.Category, Start: !m.Paused}
+	if it.Magnet() {
+		// The metadata fetch may take long, so the item is remembered at
+		// once not to be added twice.
+		r.remember(feed, it, it.InfoHash, true)
+		r.s.wg.Add(1)
+		go func() {
+			defer r.s.wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, magnetTimeout)
+			defer cancel()
+			_, err := r.s.AddMagnet(ctx, it.URL, opts)
+			switch {
+			case errors.Is(err, ErrExists):
+				log.Info("torrent of feed item is already added")
+			case errors.Is(err, context.Canceled):
+				r.remember(feed, it, it.InfoHash, false)
+			case err != nil:
+				log.Warn("could not add feed item", "err", err)
+				r.remember(feed, it, it.InfoHash, false)
+			default:
+				log.Info("added feed item")
+			}
+		}()
+		return
+	}
+	metainfo, err := rss.Fetch(ctx, r.client, it.URL)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn("could not download feed item", "url", it.URL, "err", err)
+		}
+		return
+	}
+	hash, err := r.s.AddTorrentData(metainfo, opts)
+	switch {
+	case errors.Is(err, ErrExists):
+		log.Info("torrent of feed item is already added")
+	case err != nil:
+		log.Warn("could not add feed item", "err", err)
+		return
+	default:
+		log.Info("added feed item")
+	}
+	r.remember(feed, it, hash, true)
+}
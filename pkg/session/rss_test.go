@@ -0,0 +1,258 @@
+package session
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DanArmor/GoTorrent/pkg/rss"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// testSession creates a session keeping its state in a temporary directory.
+func testSession(t *testing.T, cfg Config) *Session {
+	t.Helper()
+	dir := t.TempDir()
+	cfg.ConfigPath = filepath.Join(dir, "config")
+	cfg.DownloadPath = filepath.Join(dir, "download")
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(s.done)
+		s.stopAll()
+		s.wg.Wait()
+		s.disk.Close()
+		s.closeLog()
+	})
+	return s
+}
+
+// testMetainfo returns the metainfo of a torrent of a single small file.
+func testMetainfo(name string) []byte {
+	const announce = "http://127.0.0.1:1/announce"
+	return []byte(fmt.Sprintf("d8:announce%d:%s4:infod6:lengthi100e4:name%d:%s12:piece lengthi16384e6:pieces20:%see",
+		len(announce), announce, len(name), name, strings.Repeat("x", 20)))
+}
+
+func infoHashOf(t *testing.T, metainfo []byte) InfoHash {
+	t.Helper()
+	tf, err := torrentmeta.NewBytes(metainfo, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tf.InfoHash
+}
+
+// feedServer serves the feeds, the torrent files and a tracker without
+// peers. It counts the requests by path.
+type feedServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	hits      map[string]int
+	announced []string
+}
+
+func (fs *feedServer) count(path string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.hits[path]
+}
+
+func (fs *feedServer) announces() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string(nil), fs.announced...)
+}
+
+// client reaches the server under any host, so that only the client of the
+// session can read the feeds.
+func (fs *feedServer) client() *http.Client {
+	target, _ := url.Parse(fs.URL)
+	return &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (fn roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestReadFeeds(t *testing.T) {
+	const (
+		feedA = "http://feeds.test/a.rss"
+		feedB = "http://feeds.test/b.rss"
+	)
+	torrents := map[string][]byte{
+		"/t1.torrent":  testMetainfo("show-s01e01"),
+		"/t2.torrent":  testMetainfo("show-trailer"),
+		"/t3.torrent":  testMetainfo("show-s02e01"),
+		"/t4.torrent":  testMetainfo("show-s01e02-remux"),
+		"/t1b.torrent": testMetainfo("show-s01e01"),
+	}
+	hash1 := infoHashOf(t, torrents["/t1.torrent"])
+	magnetHash := InfoHash{0xaa, 0xbb}
+
+	fs := &feedServer{hits: make(map[string]int)}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.hits[r.URL.Path]++
+		fs.mu.Unlock()
+		switch r.URL.Path {
+		case "/a.rss":
+			magnetLink := "magnet:?xt=urn:btih:" + hex.EncodeToString(magnetHash[:]) + "&tr=" + url.QueryEscape(fs.URL+"/announce")
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel>
+	<item><title>Show S01E01</title><guid>a1</guid><enclosure url="/t1.torrent" length="100" type="application/x-bittorrent"/></item>
+	<item><title>Show S01E01 Trailer</title><guid>a2</guid><enclosure url="/t2.torrent" length="100"/></item>
+	<item><title>Show S02E01</title><guid>a3</guid><enclosure url="/t3.torrent" length="100"/></item>
+	<item><title>Show S01E02 Remux</title><guid>a4</guid><enclosure url="/t4.torrent" length="50000000000"/></item>
+	<item><title>Show S01E02</title><guid>a5</guid><link>%s</link></item>
+</channel></rss>`, strings.ReplaceAll(magnetLink, "&", "&amp;"))
+		case "/b.rss":
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0" xmlns:torznab="http://torznab.com/schemas/2015/feed"><channel>
+	<item><title>Show S01E01 mirror</title><guid>b1</guid><link>/t1b.torrent</link>
+		<torznab:attr name="infohash" value="%s"/></item>
+</channel></rss>`, hex.EncodeToString(hash1[:]))
+		case "/announce":
+			fs.mu.Lock()
+			fs.announced = append(fs.announced, r.URL.Query().Get("info_hash"))
+			fs.mu.Unlock()
+			w.Write([]byte("d8:intervali60e5:peers0:e"))
+		default:
+			if buf, ok := torrents[r.URL.Path]; ok {
+				w.Write(buf)
+			} else {
+				http.NotFound(w, r)
+			}
+		}
+	}))
+	defer fs.Close()
+
+	s := testSession(t, Config{
+		HTTPClient: fs.client(),
+		RSS: RSSConfig{Rules: []rss.Rule{{
+			Name:     "show",
+			Include:  "^show",
+			Exclude:  "trailer",
+			Episodes: "1x1-2",
+			MaxSize:  1 << 30,
+			Category: "tv",
+			Paused:   true,
+		}}},
+	})
+	ctx := context.Background()
+	r := s.newFeedReader()
+	read := func(r *feedReader, feed string) {
+		r.read(ctx, feed)
+		// Waits for the metadata fetch of the magnet links.
+		s.wg.Wait()
+	}
+
+	read(r, feedA)
+	for path, want := range map[string]int{"/a.rss": 1, "/t1.torrent": 1, "/t2.torrent": 0, "/t3.torrent": 0, "/t4.torrent": 0} {
+		if got := fs.count(path); got != want {
+			t.Errorf("%s fetched %d times, want %d", path, got, want)
+		}
+	}
+	tf, err := s.Torrent(hash1)
+	if err != nil {
+		t.Fatalf("torrent of the picked item was not added: %v", err)
+	}
+	if tf.Label != "tv" || tf.InProgress {
+		t.Errorf("added torrent has label %q and running %v, want tv and stopped", tf.Label, tf.InProgress)
+	}
+	// The magnet link is handed to the session, which asks its tracker for
+	// peers and fails for lack of them. The item is then tried again.
+	if got := fs.announces(); len(got) != 1 || got[0] != string(magnetHash[:]) {
+		t.Errorf("tracker of the magnet link got announces %q", got)
+	}
+	if _, ok := r.hist.GUIDs[feedA+" a5"]; ok {
+		t.Error("magnet link that could not be added is remembered")
+	}
+	if _, ok := r.hist.GUIDs[feedA+" a1"]; !ok {
+		t.Error("added item is not remembered")
+	}
+
+	// The same torrent in another feed is known by its info hash.
+	read(r, feedB)
+	if got := fs.count("/t1b.torrent"); got != 0 {
+		t.Errorf("torrent already added from another feed fetched %d times", got)
+	}
+	if _, ok := r.hist.GUIDs[feedB+" b1"]; !ok {
+		t.Error("item of a torrent added from another feed is not remembered")
+	}
+
+	// A second read only tries the magnet link again.
+	read(r, feedA)
+	if got := fs.count("/t1.torrent"); got != 1 {
+		t.Errorf("added item fetched again, %d times", got)
+	}
+	if got := len(fs.announces()); got != 2 {
+		t.Errorf("magnet link tried %d times, want 2", got)
+	}
+
+	// The history outlives the session and the removal of the torrent.
+	if err := s.Remove(hash1, false); err != nil {
+		t.Fatal(err)
+	}
+	read(s.newFeedReader(), feedA)
+	if got := fs.count("/t1.torrent"); got != 1 {
+		t.Errorf("item added before a restart fetched again, %d times", got)
+	}
+	if _, err := s.Torrent(hash1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removed torrent was added again: %v", err)
+	}
+}
+
+func TestReadFeedRetriesMissingTorrent(t *testing.T) {
+	const feed = "http://feeds.test/feed.rss"
+	metainfo := testMetainfo("movie")
+	var mu sync.Mutex
+	missing := true
+	fs := &feedServer{hits: make(map[string]int)}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/feed.rss":
+			w.Write([]byte(`<rss><channel><item><title>Movie</title><link>/movie.torrent</link></item></channel></rss>`))
+		case r.URL.Path == "/movie.torrent" && !missing:
+			w.Write(metainfo)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer fs.Close()
+
+	s := testSession(t, Config{
+		HTTPClient: fs.client(),
+		RSS:        RSSConfig{Rules: []rss.Rule{{Name: "all", Paused: true}}},
+	})
+	r := s.newFeedReader()
+	r.read(context.Background(), feed)
+	if len(s.Torrents()) != 0 || len(r.hist.GUIDs) != 0 {
+		t.Fatal("item without a torrent file was added or remembered")
+	}
+	mu.Lock()
+	missing = false
+	mu.Unlock()
+	r.read(context.Background(), feed)
+	if _, err := s.Torrent(infoHashOf(t, metainfo)); err != nil {
+		t.Errorf("item was not added once its torrent file appeared: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/bitfield"
+	"github.com/DanArmor/GoTorrent/pkg/client"
+	"github.com/DanArmor/GoTorrent/pkg/diskio"
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/handshake"
+	"github.com/DanArmor/GoTorrent/pkg/logging"
+	"github.com/DanArmor/GoTorrent/pkg/message"
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
+	"github.com/DanArmor/GoTorrent/pkg/ratelimit"
+	"github.com/DanArmor/GoTorrent/pkg/stats"
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+// serve uploads pieces of a completed torrent to a peer that connected to
+// us. The connection is closed once the session or the torrent stops.
+func (s *Session) serve(sessionCtx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	res, err := handshake.Read(conn)
+	if err != nil {
+		return
+	}
+	var bf bitfield.Bitfield
+	var disk *diskio.Disk
+	var ctx context.Context
+	var up, catUp *ratelimit.Limiter
+	var ts *stats.Stats
+	s.mu.Lock()
+	for _, h := range s.torrents {
+		if h.tf.InfoHash == res.InfoHash && h.tf.IsDone && h.tf.InProgress {
+			bf = h.tf.SeedBitfield()
+			disk = h.tf.Disk()
+			ctx = h.ctx
+			up = h.up
+			catUp = s.limitersOf(h.tf.Label).up
+			ts = h.tf.Stats()
+		}
+	}
+	s.mu.Unlock()
+	if disk == nil || ctx == nil {
+		s.log.Debug("rejected upload connection", logging.Peer(conn.RemoteAddr().String()))
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sessionCtx.Done():
+		case <-stop:
+			return
+		}
+		conn.Close()
+	}()
+
+	peer := conn.RemoteAddr().String()
+	s.events.Publish(event.Event{Type: event.PeerConnected, InfoHash: res.InfoHash, Peer: peer})
+	defer s.events.Publish(event.Event{Type: event.PeerDisconnected, InfoHash: res.InfoHash, Peer: peer})
+
+	conn.SetDeadline(time.Time{})
+	ps := ts.AddPeer(peer)
+	defer ts.RemovePeer(ps)
+	ps.AddRead(utils.HandshakeSize)
+	counted := stats.NewConn(ratelimit.NewConn(conn, nil, []*ratelimit.Limiter{s.up, catUp, up}), ps)
+	req := handshake.New(res.InfoHash, s.peerID)
+	if _, err := counted.Write(req.Serialize()); err != nil {
+		return
+	}
+	cl := client.Accept(counted, res.InfoHash, s.peerID)
+	cl.Stats = ps
+	defer cl.Close()
+	connected := peersConnected.With(metrics.InfoHash(res.InfoHash), "incoming")
+	connected.Inc()
+	defer connected.Dec()
+	cl.SendBitfield(bf)
+	cl.SendUnchoke()
+
+	for {
+		m, err := cl.Read()
+		if err != nil {
+			return
+		}
+		if m == nil {
+			continue
+		}
+		switch m.ID {
+		case message.MsgUnchoke:
+			cl.SetChoked(false)
+		case message.MsgChoke:
+			cl.SetChoked(true)
+		case message.MsgRequest:
+			index, begin, length, err := message.ParseRequest(m)
+			if err != nil {
+				return
+			}
+			if !bf.HasPiece(index) {
+				continue
+			}
+			err = disk.Serve(index, begin, length, func(b []byte) error {
+				return cl.SendPiece(index, begin, b)
+			})
+			if err != nil {
+				s.log.Error("could not serve piece", logging.InfoHash(res.InfoHash), logging.Peer(peer), "piece", index, "err", err)
+				s.events.Publish(event.Event{Type: event.StorageError, InfoHash: res.InfoHash, Piece: index, Message: err.Error()})
+				return
+			}
+		}
+	}
+}
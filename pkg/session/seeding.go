@@ -0,0 +1,136 @@
+package session
+
+import (
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// SeedLimits returns the seeding limits of the session.
+func (s *Session) SeedLimits() torrentmeta.SeedLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.Seeding
+}
+
+// SetSeedLimits changes the seeding limits of the session for this run.
+func (s *Session) SetSeedLimits(limits torrentmeta.SeedLimits) error {
+	if _, err := torrentmeta.ParseSeedAction(string(limits.Action)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cfg.Seeding = limits
+	s.mu.Unlock()
+	return nil
+}
+
+// SetTorrentSeedLimits changes the seeding limits of a torrent. Zero values
+// use the limits of its category or the session and negative ones disable
+// them.
+func (s *Session) SetTorrentSeedLimits(hash InfoHash, limits torrentmeta.SeedLimits) error {
+	if _, err := torrentmeta.ParseSeedAction(string(limits.Action)); err != nil {
+		return err
+	}
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	h.tf.SeedLimits = limits
+	s.mu.Unlock()
+	s.save(h.tf)
+	return nil
+}
+
+// SeedGoal returns what is left before the torrent reaches its seeding
+// limits.
+func (s *Session) SeedGoal(hash InfoHash) (torrentmeta.SeedGoal, error) {
+	h, err := s.find(hash)
+	if err != nil {
+		return torrentmeta.SeedGoal{}, err
+	}
+	return s.goal(h, time.Now()), nil
+}
+
+func (s *Session) goal(h *handle, now time.Time) torrentmeta.SeedGoal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var idle time.Duration
+	if !h.idleSince.IsZero() {
+		idle = now.Sub(h.idleSince)
+	}
+	return h.tf.SeedGoal(s.seedLimitsOf(h.tf.Label), idle)
+}
+
+// seeds reports whether the torrent is complete and running.
+func seeds(tf *torrentmeta.TorrentFile) bool {
+	return tf.InProgress && tf.IsDone
+}
+
+// checkSeeding counts the seed time and the idle time of the seeding
+// torrents and runs the action of those that reached their limits.
+func (s *Session) checkSeeding(now time.Time) {
+	s.mu.Lock()
+	var reached []*handle
+	for _, h := range s.torrents {
+		if !seeds(h.tf) {
+			h.seeding, h.idleSince = time.Time{}, time.Time{}
+			continue
+		}
+		uploaded := h.tf.Stats().Counters().Uploaded
+		if h.seeding.IsZero() {
+			h.seeding, h.idleSince, h.uploaded = now, now, uploaded
+			continue
+		}
+		h.tf.SeedTime += now.Sub(h.seeding)
+		h.seeding = now
+		if uploaded > h.uploaded {
+			h.idleSince, h.uploaded = now, uploaded
+		}
+		if h.tf.SeedGoal(s.seedLimitsOf(h.tf.Label), now.Sub(h.idleSince)).Reached != "" {
+			reached = append(reached, h)
+		}
+	}
+	s.mu.Unlock()
+	for _, h := range reached {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		s.seedGoalReached(h)
+	}
+}
+
+func (s *Session) seedGoalReached(h *handle) {
+	tf := h.tf
+	goal := s.goal(h, time.Now())
+	log := s.torrentLog("torrent", tf)
+	log.Info("seeding goal reached", "limit", goal.Reached, "action", goal.Limits.Action,
+		"ratio", goal.Ratio, "seed_time", tf.SeedTime.Round(time.Second))
+	s.publish(event.SeedGoalReached, tf, goal.Reached)
+	var err error
+	switch goal.Limits.Action {
+	case torrentmeta.SeedRemove:
+		err = s.Remove(tf.InfoHash, false)
+	case torrentmeta.SeedRemoveData:
+		err = s.Remove(tf.InfoHash, true)
+	default:
+		s.stop(h)
+		s.schedule()
+	}
+	if err != nil {
+		log.Error("could not remove", "err", err)
+	}
+}
+
+// saveSeeding saves the state of the seeding torrents, so their seed time
+// outlives a crash.
+func (s *Session) saveSeeding() {
+	for _, tf := range s.Torrents() {
+		if seeds(tf) {
+			s.save(tf)
+		}
+	}
+}
@@ -0,0 +1,969 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/diskio"
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/logging"
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
+	"github.com/DanArmor/GoTorrent/pkg/ratelimit"
+	"github.com/DanArmor/GoTorrent/pkg/stats"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+var (
+	ErrNotFound = errors.New("torrent not found")
+	ErrExists   = errors.New("torrent is already added")
+	ErrRunning  = errors.New("torrent must be stopped first")
+	// ErrInvalid is returned for metainfo and magnet links that can not
+	// be parsed.
+	ErrInvalid = errors.New("invalid torrent")
+)
+
+type InfoHash = [utils.InfoHashLen]byte
+
+const mmapFlushInterval = 30 * time.Second
+
+// gobSuffix ends the names of the gob files torrents were saved to by
+// earlier versions. They are migrated to the state directory on start.
+const gobSuffix = "_meta.meta"
+
+const stateDir = "torrents"
+
+// progressInterval is how often the progress of a recheck is published.
+const progressInterval = 500 * time.Millisecond
+
+// handle is a torrent of the session. ctx is cancelled when a seeding
+// torrent stops, which closes its upload connections.
+type handle struct {
+	tf   *torrentmeta.TorrentFile
+	ctx  context.Context
+	down *ratelimit.Limiter
+	up   *ratelimit.Limiter
+	// autostart starts the torrent once its recheck is done.
+	autostart bool
+	// seeding is when the seed time was last counted and idleSince when
+	// the torrent last uploaded, while it seeds. uploaded is what it had
+	// uploaded then.
+	seeding   time.Time
+	idleSince time.Time
+	uploaded  int64
+	// startedAt is when the torrent was last started.
+	startedAt time.Time
+	// op serializes starting, stopping, checking, moving and removing the
+	// torrent.
+	op sync.Mutex
+	// removed is set once the torrent is removed, and cancelCheck while it
+	// is checked, under s.mu.
+	removed     bool
+	cancelCheck context.CancelFunc
+}
+
+// AddOptions changes how a torrent is added.
+type AddOptions struct {
+	// SavePath replaces the download directory of the session.
+	SavePath string
+	// Start starts the torrent once it is added and checked.
+	Start bool
+	Label string
+}
+
+// newHandle makes tf a torrent of the session, counting its transfers in
+// the session totals.
+func (s *Session) newHandle(tf *torrentmeta.TorrentFile) *handle {
+	tf.Stats().SetParent(s.stats)
+	return &handle{
+		tf:   tf,
+		down: ratelimit.New(tf.DownloadLimit),
+		up:   ratelimit.New(tf.UploadLimit),
+	}
+}
+
+// Session owns the torrents, the disk I/O and the listening socket. All
+// background work is tied to Run, which must be called once.
+type Session struct {
+	cfg      Config
+	open     storage.Opener
+	peerID   [utils.PeerIDLen]byte
+	disk     *diskio.Pool
+	events   *event.Bus
+	root     *slog.Logger
+	log      *slog.Logger
+	level    slog.LevelVar
+	ring     *logging.Ring
+	logFile  *logging.RotatingFile
+	down     *ratelimit.Limiter
+	up       *ratelimit.Limiter
+	stats    *stats.Stats
+	started  time.Time
+	mu       sync.Mutex
+	torrents []*handle
+	wg       sync.WaitGroup
+	// queueMu serializes the starts of queued torrents.
+	queueMu sync.Mutex
+	// saveMu orders the writes of the torrent states with their removal.
+	saveMu sync.Mutex
+	// catMu serializes the changes of the categories with their writes to
+	// the configuration file.
+	catMu sync.Mutex
+	// catLimits are the speed limiters of the categories.
+	catLimits map[string]categoryLimiters
+	// done is closed when the session shuts down.
+	done chan struct{}
+}
+
+// New creates a session and loads the torrents saved in cfg.ConfigPath.
+func New(cfg Config) (*Session, error) {
+	if cfg.ListenPort == 0 {
+		cfg.ListenPort = torrentmeta.Port
+	}
+	if err := os.MkdirAll(cfg.ConfigPath, 0770); err != nil {
+		return nil, err
+	}
+	s := &Session{
+		cfg:    cfg,
+		open:   cfg.opener(),
+		events: event.NewBus(),
+		down:   ratelimit.New(cfg.DownloadLimit),
+		up:     ratelimit.New(cfg.UploadLimit),
+		done:   make(chan struct{}),
+	}
+	if err := s.openLog(); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(s.peerID[:]); err != nil {
+		return nil, err
+	}
+	if err := s.loadStats(); err != nil {
+		s.closeLog()
+		return nil, err
+	}
+	s.initCategories()
+	s.disk = diskio.New(cfg.Disk)
+	if err := s.loadTorrents(); err != nil {
+		s.disk.Close()
+		s.closeLog()
+		return nil, err
+	}
+	s.registerMetrics()
+	return s, nil
+}
+
+func (s *Session) Config() Config {
+	return s.cfg
+}
+
+func (s *Session) PeerID() [utils.PeerIDLen]byte {
+	return s.peerID
+}
+
+// Subscribe returns a stream of the events of the session. It must be
+// closed when no longer read.
+func (s *Session) Subscribe(size int) *event.Subscription {
+	return s.events.Subscribe(size)
+}
+
+func (s *Session) publish(typ event.Type, tf *torrentmeta.TorrentFile, msg string) {
+	s.events.Publish(event.Event{Type: typ, InfoHash: tf.InfoHash, Message: msg})
+}
+
+// Run accepts upload connections until ctx is done. It then stops all
+// torrents, waits for the background work and releases the disk pool.
+func (s *Session) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", s.cfg.ListenPort))
+	if err != nil {
+		s.log.Error("can't listen", "port", s.cfg.ListenPort, "err", err)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.updateStats()
+	}()
+	s.watchFolders(ctx)
+	s.readFeeds(ctx)
+	var conns sync.WaitGroup
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		if ln == nil {
+			return
+		}
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conns.Done()
+				s.serve(ctx, conn)
+			}()
+		}
+	}()
+	<-ctx.Done()
+	close(s.done)
+	if ln != nil {
+		ln.Close()
+	}
+	<-accepting
+	s.stopAll()
+	conns.Wait()
+	s.wg.Wait()
+	s.saveStats()
+	s.disk.Close()
+	s.closeLog()
+	return nil
+}
+
+func (s *Session) stateDir() string {
+	return filepath.Join(s.cfg.ConfigPath, stateDir)
+}
+
+// save writes the state of a torrent of the session. Removed torrents are
+// not saved again.
+func (s *Session) save(tf *torrentmeta.TorrentFile) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	kept := false
+	for _, h := range s.torrents {
+		if h.tf == tf && !h.removed {
+			kept = true
+		}
+	}
+	s.mu.Unlock()
+	if !kept {
+		return
+	}
+	if err := tf.Save(s.stateDir()); err != nil {
+		s.torrentLog("torrent", tf).Error("could not save state", "err", err)
+	}
+}
+
+// downloadDir returns where new torrents of the category are stored.
+func (s *Session) downloadDir(label string) string {
+	if s.cfg.IncompletePath != "" {
+		return s.cfg.IncompletePath
+	}
+	return s.savePath(label)
+}
+
+// completedDir returns where completed torrents of the category are moved
+// to, or an empty string when they stay in place.
+func (s *Session) completedDir(label string) string {
+	if s.cfg.CompletedPath != "" {
+		return s.cfg.CompletedPath
+	}
+	if s.cfg.IncompletePath != "" {
+		return s.savePath(label)
+	}
+	return ""
+}
+
+func (s *Session) find(hash InfoHash) (*handle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.torrents {
+		if h.tf.InfoHash == hash {
+			return h, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *Session) loadTorrents() error {
+	if err := s.migrate(); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.stateDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !torrentmeta.IsStateFile(e.Name()) {
+			continue
+		}
+		tf, err := torrentmeta.Load(filepath.Join(s.stateDir(), e.Name()))
+		if err != nil {
+			s.log.Error("could not load torrent", "err", err)
+			continue
+		}
+		s.torrents = append(s.torrents, s.newHandle(tf))
+	}
+	sort.SliceStable(s.torrents, func(i, j int) bool {
+		return s.torrents[i].tf.AddedAt.Before(s.torrents[j].tf.AddedAt)
+	})
+	sort.SliceStable(s.torrents, func(i, j int) bool {
+		return s.torrents[i].tf.QueuePosition < s.torrents[j].tf.QueuePosition
+	})
+	for _, tf := range s.renumber() {
+		s.save(tf)
+	}
+	for _, h := range s.torrents {
+		wasDone := h.tf.IsDone
+		if h.tf.ValidateResume() {
+			s.torrentLog("torrent", h.tf).Warn("files changed, rechecking")
+			h.op.Lock()
+			s.recheck(h)
+			h.op.Unlock()
+		} else if !wasDone && h.tf.IsDone {
+			s.finalize(h.tf)
+			s.save(h.tf)
+		}
+	}
+	return nil
+}
+
+// migrate moves torrents saved as gob files into the state directory. The
+// gob files are kept with a .migrated suffix.
+func (s *Session) migrate() error {
+	entries, err := os.ReadDir(s.cfg.ConfigPath)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), gobSuffix) {
+			continue
+		}
+		path := filepath.Join(s.cfg.ConfigPath, e.Name())
+		tf, err := torrentmeta.LoadGob(path)
+		if err != nil {
+			s.log.Error("could not migrate torrent", "file", path, "err", err)
+			continue
+		}
+		if _, err := os.Stat(torrentmeta.StatePath(s.stateDir(), tf.InfoHash)); err == nil {
+			s.log.Warn("torrent is already migrated", "file", path)
+		} else if err := tf.Save(s.stateDir()); err != nil {
+			return err
+		}
+		if err := os.Rename(path, path+".migrated"); err != nil {
+			return err
+		}
+		s.torrentLog("torrent", tf).Info("migrated gob state", "file", path)
+	}
+	return nil
+}
+
+// AddTorrent adds the torrent file at path. Data already on disk is checked
+// before the torrent can be started.
+func (s *Session) AddTorrent(path string, opts AddOptions) (InfoHash, error) {
+	metainfo, err := os.ReadFile(path)
+	if err != nil {
+		return InfoHash{}, err
+	}
+	return s.AddTorrentData(metainfo, opts)
+}
+
+// AddTorrentData adds a torrent from its metainfo, like AddTorrent.
+func (s *Session) AddTorrentData(metainfo []byte, opts AddOptions) (InfoHash, error) {
+	dir := opts.SavePath
+	if dir == "" {
+		dir = s.downloadDir(opts.Label)
+	}
+	tf, err := torrentmeta.NewBytes(metainfo, dir)
+	if err != nil {
+		return InfoHash{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	tf.Label = opts.Label
+	h := s.newHandle(tf)
+	h.autostart = opts.Start
+	s.mu.Lock()
+	for _, other := range s.torrents {
+		if other.tf.InfoHash == tf.InfoHash {
+			s.mu.Unlock()
+			return tf.InfoHash, ErrExists
+		}
+	}
+	tf.QueuePosition = len(s.torrents)
+	s.torrents = append(s.torrents, h)
+	s.mu.Unlock()
+
+	anyFileExists := false
+	for i := range tf.Files {
+		if _, err := os.Stat(tf.Files[i].FullPath); err == nil {
+			anyFileExists = true
+			break
+		}
+	}
+	if !anyFileExists {
+		tf.CaptureResume()
+	}
+	s.save(tf)
+	s.publish(event.TorrentAdded, tf, tf.Name)
+	if anyFileExists {
+		h.op.Lock()
+		s.recheck(h)
+		h.op.Unlock()
+	} else if opts.Start {
+		s.enqueue(h)
+	}
+	return tf.InfoHash, nil
+}
+
+// Remove stops the torrent and forgets it. With deleteData its files are
+// removed from disk as well.
+func (s *Session) Remove(hash InfoHash, deleteData bool) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	s.cancelRecheck(h)
+	h.op.Lock()
+	defer h.op.Unlock()
+	s.mu.Lock()
+	removed := h.removed
+	s.mu.Unlock()
+	if removed {
+		return ErrNotFound
+	}
+	s.halt(h)
+	s.mu.Lock()
+	h.removed = true
+	for i := range s.torrents {
+		if s.torrents[i] == h {
+			s.torrents = append(s.torrents[:i], s.torrents[i+1:]...)
+			break
+		}
+	}
+	changed := s.renumber()
+	s.mu.Unlock()
+	for _, tf := range changed {
+		s.save(tf)
+	}
+	s.saveMu.Lock()
+	torrentmeta.RemoveState(s.stateDir(), h.tf.InfoHash)
+	s.saveMu.Unlock()
+	metrics.Default.Forget("info_hash", metrics.InfoHash(h.tf.InfoHash))
+	s.publish(event.TorrentRemoved, h.tf, h.tf.Name)
+	s.schedule()
+	if deleteData {
+		return h.tf.RemoveFiles()
+	}
+	return nil
+}
+
+func (s *Session) Pause(hash InfoHash) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	s.stop(h)
+	s.schedule()
+	return nil
+}
+
+// Resume queues the torrent, which starts at once if the queue has room.
+func (s *Session) Resume(hash InfoHash) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	s.enqueue(h)
+	return nil
+}
+
+func (s *Session) Status(hash InfoHash) (Status, error) {
+	h, err := s.find(hash)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.statusOf(h), nil
+}
+
+// List returns the status of every torrent, in queue order.
+func (s *Session) List() []Status {
+	s.mu.Lock()
+	handles := append([]*handle(nil), s.torrents...)
+	s.mu.Unlock()
+	var list []Status
+	for _, h := range handles {
+		list = append(list, s.statusOf(h))
+	}
+	return list
+}
+
+// Details returns the details of a torrent.
+func (s *Session) Details(hash InfoHash) (Details, error) {
+	h, err := s.find(hash)
+	if err != nil {
+		return Details{}, err
+	}
+	return s.detailsOf(h), nil
+}
+
+// Torrents returns the torrents of the session, in queue order. They must only be changed through the session,
+// and their state is read through Status and Details.
+func (s *Session) Torrents() []*torrentmeta.TorrentFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*torrentmeta.TorrentFile, len(s.torrents))
+	for i, h := range s.torrents {
+		list[i] = h.tf
+	}
+	return list
+}
+
+func (s *Session) Torrent(hash InfoHash) (*torrentmeta.TorrentFile, error) {
+	h, err := s.find(hash)
+	if err != nil {
+		return nil, err
+	}
+	return h.tf, nil
+}
+
+func (s *Session) SetFilePriority(hash InfoHash, file int, p torrentmeta.Priority) error {
+	return s.SetFilePriorities(hash, map[int]torrentmeta.Priority{file: p})
+}
+
+// SetFilePriorities changes the priorities of files of a torrent, by file
+// index. A running torrent is stopped while they change and started again
+// afterwards.
+func (s *Session) SetFilePriorities(hash InfoHash, prios map[int]torrentmeta.Priority) error {
+	return s.modify(hash, func(tf *torrentmeta.TorrentFile) error {
+		for file := range prios {
+			if file < 0 || file >= len(tf.Files) {
+				return fmt.Errorf("no file %d in <%s>", file, tf.Name)
+			}
+		}
+		for file, p := range prios {
+			tf.SetFilePriority(file, p)
+		}
+		return nil
+	})
+}
+
+// Limits returns the download and upload limits of the session in bytes per
+// second.
+func (s *Session) Limits() (down int, up int) {
+	return s.down.Rate(), s.up.Rate()
+}
+
+// SetLimits changes the limits of the session for this run. Zero removes a
+// limit.
+func (s *Session) SetLimits(down int, up int) {
+	s.down.SetRate(down)
+	s.up.SetRate(up)
+}
+
+// SetTorrentLimits changes the limits of a torrent. They apply on top of the
+// limits of the session.
+func (s *Session) SetTorrentLimits(hash InfoHash, down int, up int) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	h.tf.DownloadLimit = max(down, 0)
+	h.tf.UploadLimit = max(up, 0)
+	s.mu.Unlock()
+	h.down.SetRate(down)
+	h.up.SetRate(up)
+	s.save(h.tf)
+	return nil
+}
+
+// Recheck starts a full recheck of a stopped torrent, or cancels the running
+// one.
+func (s *Session) Recheck(hash InfoHash) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	if s.cancelRecheck(h) {
+		return nil
+	}
+	h.op.Lock()
+	defer h.op.Unlock()
+	if h.tf.InProgress {
+		return ErrRunning
+	}
+	h.tf.RecheckState = nil
+	s.recheck(h)
+	return nil
+}
+
+// Go runs fn in the background as work of the session: ctx is cancelled
+// when the session shuts down and Run waits for fn to return.
+func (s *Session) Go(fn func(ctx context.Context)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		fn(ctx)
+	}()
+}
+
+// recheck checks the data of a stopped torrent in the background. h.op
+// must be held.
+func (s *Session) recheck(h *handle) {
+	tf := h.tf
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if h.cancelCheck != nil || tf.InProgress {
+		s.mu.Unlock()
+		cancel()
+		return
+	}
+	h.cancelCheck = cancel
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		stop := make(chan struct{})
+		go s.recheckProgress(tf, stop)
+		err := tf.Recheck(ctx, s.open)
+		close(stop)
+		s.mu.Lock()
+		h.cancelCheck = nil
+		s.mu.Unlock()
+		cancel()
+		if err != nil && !errors.Is(err, context.Canceled) {
+			s.torrentLog("torrent", tf).Error("recheck failed", "err", err)
+			s.publish(event.TorrentError, tf, err.Error())
+		}
+		if err == nil && tf.IsDone {
+			s.finalize(tf)
+		}
+		s.save(tf)
+		s.publish(event.RecheckFinished, tf, "")
+		s.mu.Lock()
+		autostart := h.autostart && err == nil
+		h.autostart = false
+		s.mu.Unlock()
+		select {
+		case <-s.done:
+		default:
+			if autostart {
+				s.enqueue(h)
+			}
+		}
+	}()
+}
+
+// checking reports whether a recheck of the torrent was started and is not
+// done yet.
+func (s *Session) checking(h *handle) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return h.cancelCheck != nil
+}
+
+// cancelRecheck stops the recheck of the torrent and reports whether one
+// was running.
+func (s *Session) cancelRecheck(h *handle) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h.cancelCheck == nil {
+		return false
+	}
+	h.cancelCheck()
+	return true
+}
+
+// recheckProgress publishes the progress of a recheck until stop is closed.
+func (s *Session) recheckProgress(tf *torrentmeta.TorrentFile, stop chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if checking, progress := tf.Checking(); checking {
+				s.events.Publish(event.Event{Type: event.RecheckProgress, InfoHash: tf.InfoHash, Progress: progress})
+			}
+		}
+	}
+}
+
+// Move moves the files of a torrent to dir in the background. A running
+// torrent is stopped for the move and started again afterwards.
+func (s *Session) Move(hash InfoHash, dir string) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	tf := h.tf
+	if dir == "" {
+		return fmt.Errorf("no directory to move <%s> to", tf.Name)
+	}
+	h.op.Lock()
+	defer h.op.Unlock()
+	if s.checking(h) || tf.Moving() {
+		return fmt.Errorf("<%s> is busy", tf.Name)
+	}
+	running := tf.InProgress || tf.Queued
+	s.halt(h)
+	s.move(h, dir, running)
+	return nil
+}
+
+// moveCompleted moves a torrent that finished downloading to dir, unless it
+// was removed, moved or started again meanwhile.
+func (s *Session) moveCompleted(h *handle, dir string) {
+	h.op.Lock()
+	defer h.op.Unlock()
+	s.mu.Lock()
+	idle := !h.removed && !h.tf.InProgress && !h.tf.Queued && h.tf.IsDone
+	s.mu.Unlock()
+	if !idle || s.checking(h) || h.tf.Moving() {
+		return
+	}
+	s.move(h, dir, false)
+}
+
+// move moves the files of a stopped torrent to dir in the background and
+// queues it again when running is set. h.op must be held.
+func (s *Session) move(h *handle, dir string, running bool) {
+	tf := h.tf
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		log := s.torrentLog("torrent", tf)
+		log.Info("moving", "dir", dir)
+		s.publish(event.MoveStarted, tf, dir)
+		if err := tf.Move(dir); err != nil {
+			log.Error("could not move", "dir", dir, "err", err)
+			tf.SetError(err)
+			s.publish(event.TorrentError, tf, err.Error())
+			return
+		}
+		s.save(tf)
+		log.Info("moved", "dir", dir)
+		s.publish(event.MoveFinished, tf, dir)
+		if running {
+			s.enqueue(h)
+		}
+	}()
+}
+
+// modify applies fn to the files of a torrent. A running torrent is stopped
+// while its files change and started again afterwards.
+func (s *Session) modify(hash InfoHash, fn func(tf *torrentmeta.TorrentFile) error) error {
+	h, err := s.find(hash)
+	if err != nil {
+		return err
+	}
+	tf := h.tf
+	h.op.Lock()
+	if s.checking(h) || tf.Moving() {
+		h.op.Unlock()
+		return fmt.Errorf("<%s> is busy", tf.Name)
+	}
+	running := tf.InProgress || tf.Queued
+	s.halt(h)
+	err = fn(tf)
+	s.save(tf)
+	h.op.Unlock()
+	if running {
+		s.enqueue(h)
+	}
+	return err
+}
+
+func (s *Session) RenameFile(hash InfoHash, file int, name string) error {
+	return s.modify(hash, func(tf *torrentmeta.TorrentFile) error {
+		if file < 0 || file >= len(tf.Files) {
+			return fmt.Errorf("no file %d in <%s>", file, tf.Name)
+		}
+		return tf.RenameFile(file, name)
+	})
+}
+
+func (s *Session) RenameRoot(hash InfoHash, name string) error {
+	return s.modify(hash, func(tf *torrentmeta.TorrentFile) error {
+		return tf.RenameRoot(name)
+	})
+}
+
+func (s *Session) stopAll() {
+	s.mu.Lock()
+	list := append([]*handle(nil), s.torrents...)
+	s.mu.Unlock()
+	for _, h := range list {
+		s.cancelRecheck(h)
+		s.stop(h)
+	}
+}
+
+// announce tells the tracker of a seeding torrent about it.
+func (s *Session) announce(tf *torrentmeta.TorrentFile) {
+	log := s.torrentLog("tracker", tf)
+	if _, err := tf.RequestPeers(s.peerID, s.cfg.ListenPort); err != nil {
+		log.Warn("can't announce", "err", err)
+		s.publish(event.TrackerError, tf, err.Error())
+		return
+	}
+	log.Debug("announced", "tracker", tf.Announce)
+	s.publish(event.TrackerAnnounced, tf, tf.Announce)
+}
+
+func (s *Session) start(h *handle) error {
+	h.op.Lock()
+	defer h.op.Unlock()
+	tf := h.tf
+	log := s.torrentLog("torrent", tf)
+	if s.checking(h) || tf.Moving() || tf.InProgress {
+		return nil
+	}
+	if tf.RecheckState != nil {
+		// The pending recheck runs first.
+		s.mu.Lock()
+		h.autostart = true
+		s.mu.Unlock()
+		s.recheck(h)
+		return nil
+	}
+	tf.SetError(nil)
+	if !tf.IsDone {
+		if err := tf.CheckFreeSpace(s.cfg.Allocation); err != nil {
+			log.Error("can't start", "err", err)
+			tf.SetError(err)
+			s.publish(event.TorrentError, tf, err.Error())
+			return err
+		}
+	}
+	if err := tf.OpenDisk(s.disk, s.open); err != nil {
+		log.Error("could not open storage", "err", err)
+		tf.SetError(err)
+		s.publish(event.StorageError, tf, err.Error())
+		return err
+	}
+	s.wg.Add(1)
+	s.mu.Lock()
+	tf.InProgress = true
+	h.startedAt = time.Now()
+	s.mu.Unlock()
+	tf.Count = make(chan int)
+	tf.Done = make(chan struct{}, 1)
+	tf.Out = make(chan struct{}, 1)
+	s.publish(event.TorrentStarted, tf, "")
+	if tf.IsDone {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.mu.Lock()
+		h.ctx = ctx
+		s.mu.Unlock()
+		// The tracker may be slow to answer, and start runs with the
+		// queue locked.
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.announce(tf)
+		}()
+		go func() {
+			defer s.wg.Done()
+			<-tf.Done
+			cancel()
+			tf.CloseDisk()
+			tf.Out <- struct{}{}
+		}()
+		return nil
+	}
+	go func() {
+		defer s.wg.Done()
+		s.mu.Lock()
+		limiters := []*ratelimit.Limiter{s.down, s.limitersOf(tf.Label).down, h.down}
+		s.mu.Unlock()
+		err := tf.DownloadToFile(s.peerID, s.cfg.ListenPort, s.torrentLog("p2p", tf), s.events, limiters)
+		var moveTo string
+		tf.CloseDisk()
+		tf.CaptureResume()
+		if err != nil {
+			log.Warn("download paused", "err", err)
+			tf.SetError(err)
+			s.mu.Lock()
+			tf.InProgress = false
+			s.mu.Unlock()
+			s.save(tf)
+			s.publish(event.TorrentError, tf, err.Error())
+		} else if tf.Completed() {
+			s.mu.Lock()
+			tf.IsDone = true
+			s.mu.Unlock()
+			// The torrent stays in progress while its files are finalized,
+			// so that nothing else works on them meanwhile.
+			if s.finalize(tf) {
+				moveTo = s.completedDir(tf.Label)
+			}
+			s.mu.Lock()
+			tf.InProgress = false
+			s.mu.Unlock()
+			s.save(tf)
+			if tf.IsDone {
+				log.Info("finished")
+				s.publish(event.TorrentFinished, tf, tf.Name)
+			}
+		}
+		tf.Out <- struct{}{}
+		if moveTo != "" {
+			// The move goes through h.op like the other moves, which a
+			// halt waiting for tf.Out may hold until now.
+			s.moveCompleted(h, moveTo)
+		}
+		s.schedule()
+	}()
+	return nil
+}
+
+// finalize applies the file attributes of a torrent that became complete.
+// It reports whether the torrent is still complete, which it is not when
+// files fail their sha1.
+func (s *Session) finalize(tf *torrentmeta.TorrentFile) bool {
+	if err := tf.FinalizeFiles(); err != nil {
+		s.torrentLog("torrent", tf).Error("files are not as expected", "err", err)
+		tf.SetError(err)
+		s.publish(event.TorrentError, tf, err.Error())
+	}
+	return tf.IsDone
+}
+
+func (s *Session) stop(h *handle) {
+	h.op.Lock()
+	defer h.op.Unlock()
+	s.halt(h)
+}
+
+// halt stops the torrent if it runs and takes it out of the queue. h.op
+// must be held.
+func (s *Session) halt(h *handle) {
+	tf := h.tf
+	s.mu.Lock()
+	tf.Queued = false
+	running := tf.InProgress
+	s.mu.Unlock()
+	if !running {
+		return
+	}
+	tf.Done <- struct{}{}
+	<-tf.Out
+	s.mu.Lock()
+	tf.InProgress = false
+	s.mu.Unlock()
+	s.save(tf)
+	s.publish(event.TorrentStopped, tf, "")
+}
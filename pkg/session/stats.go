@@ -0,0 +1,110 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/stats"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
+)
+
+const statsName = "stats.json"
+
+const (
+	// statsInterval is how often the rates are updated.
+	statsInterval = time.Second
+	// statsSaveInterval is how often the all-time totals are saved.
+	statsSaveInterval = time.Minute
+)
+
+// Totals are the transfers of all torrents, including removed ones.
+type Totals struct {
+	// Session counts since the session started, AllTime since the first
+	// run.
+	Session stats.Counters
+	AllTime stats.Counters
+	// DownloadRate and UploadRate are the payload rates in bytes per
+	// second.
+	DownloadRate int
+	UploadRate   int
+	StartedAt    time.Time
+}
+
+func (s *Session) statsPath() string {
+	return filepath.Join(s.cfg.ConfigPath, statsName)
+}
+
+// loadStats creates the session counters, starting the all-time totals
+// from the saved ones.
+func (s *Session) loadStats() error {
+	var base stats.Counters
+	buf, err := os.ReadFile(s.statsPath())
+	if err == nil {
+		err = json.Unmarshal(buf, &base)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.stats = stats.New(base)
+	s.started = time.Now()
+	return nil
+}
+
+func (s *Session) saveStats() {
+	buf, err := json.MarshalIndent(s.stats.Total(), "", "\t")
+	if err == nil {
+		err = storage.WriteFile(s.statsPath(), append(buf, '\n'), 0644)
+	}
+	if err != nil {
+		s.log.Error("could not save stats", "err", err)
+	}
+}
+
+// updateStats updates the rates and the seeding goals and starts queued
+// torrents in place of slow ones every statsInterval, and saves the totals
+// every statsSaveInterval, until the session is done.
+func (s *Session) updateStats() {
+	tick := time.NewTicker(statsInterval)
+	defer tick.Stop()
+	save := time.NewTicker(statsSaveInterval)
+	defer save.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-tick.C:
+			s.stats.Tick(now)
+			for _, tf := range s.Torrents() {
+				tf.Stats().Tick(now)
+			}
+			s.checkSeeding(now)
+			s.schedule()
+		case <-save.C:
+			s.saveStats()
+			s.saveSeeding()
+		}
+	}
+}
+
+func (s *Session) Totals() Totals {
+	down, up := s.stats.Rates()
+	return Totals{
+		Session:      s.stats.Counters(),
+		AllTime:      s.stats.Total(),
+		DownloadRate: int(down),
+		UploadRate:   int(up),
+		StartedAt:    s.started,
+	}
+}
+
+// Peers returns the peers the torrent is connected to.
+func (s *Session) Peers(hash InfoHash) ([]stats.Peer, error) {
+	h, err := s.find(hash)
+	if err != nil {
+		return nil, err
+	}
+	return h.tf.Stats().Peers(), nil
+}
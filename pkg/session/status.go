@@ -0,0 +1,188 @@
+package session
+
+import (
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+type State string
+
+const (
+	StateStopped     State = "Stopped"
+	StateDownloading State = "Downloading"
+	StateUploading   State = "Uploading"
+	StateChecking    State = "Checking"
+	StateCheckPaused State = "Check paused"
+	StateQueued      State = "Queued"
+	StateMoving      State = "Moving"
+	StateDiskFull    State = "Disk full"
+	StateError       State = "Error"
+)
+
+type Status struct {
+	InfoHash InfoHash
+	Name     string
+	Label    string
+	State    State
+	// Checked is the progress of a running recheck.
+	Checked  float64
+	Size     int
+	Progress float64
+	SavePath string
+	Error    string
+	// DownloadLimit and UploadLimit are the limits of the torrent in bytes
+	// per second.
+	DownloadLimit int
+	UploadLimit   int
+	// Downloaded and Uploaded are the payload bytes moved since the
+	// torrent was added, and the rates those of the last seconds.
+	Downloaded   int64
+	Uploaded     int64
+	Ratio        float64
+	DownloadRate int
+	UploadRate   int
+	Peers        int
+	// QueuePosition is the place of the torrent in the queue, from 0.
+	QueuePosition int
+	// SeedTime is how long the torrent has seeded and SeedGoal what is
+	// left of its seeding limits.
+	SeedTime time.Duration
+	SeedGoal torrentmeta.SeedGoal
+}
+
+func stateOf(tf *torrentmeta.TorrentFile) (State, float64) {
+	if tf.Moving() {
+		return StateMoving, 0
+	}
+	if checking, progress := tf.Checking(); checking {
+		return StateChecking, progress
+	}
+	if tf.RecheckState != nil {
+		return StateCheckPaused, 0
+	}
+	if tf.Queued {
+		return StateQueued, 0
+	}
+	if tf.InProgress {
+		if tf.IsDone {
+			return StateUploading, 0
+		}
+		return StateDownloading, 0
+	}
+	if tf.DiskFull {
+		return StateDiskFull, 0
+	}
+	if tf.Error != "" {
+		return StateError, 0
+	}
+	return StateStopped, 0
+}
+
+func statusOf(tf *torrentmeta.TorrentFile) Status {
+	state, checked := stateOf(tf)
+	total := tf.Stats().Total()
+	down, up := tf.Stats().Rates()
+	return Status{
+		InfoHash:      tf.InfoHash,
+		Name:          tf.Name,
+		Label:         tf.Label,
+		State:         state,
+		Checked:       checked,
+		Size:          tf.TotalSize,
+		Progress:      tf.Progress(),
+		SavePath:      tf.SavePath,
+		Error:         tf.Error,
+		DownloadLimit: tf.DownloadLimit,
+		UploadLimit:   tf.UploadLimit,
+		Downloaded:    total.Downloaded,
+		Uploaded:      total.Uploaded,
+		Ratio:         tf.Ratio(),
+		DownloadRate:  int(down),
+		UploadRate:    int(up),
+		Peers:         len(tf.Stats().Peers()),
+		QueuePosition: tf.QueuePosition,
+		SeedTime:      tf.SeedTime,
+	}
+}
+
+func (s *Session) statusOf(h *handle) Status {
+	s.mu.Lock()
+	st := statusOf(h.tf)
+	s.mu.Unlock()
+	st.SeedGoal = s.goal(h, time.Now())
+	return st
+}
+
+// Details is the status of a torrent with its metainfo and files.
+type Details struct {
+	Status
+	Comment   string
+	CreatedBy string
+	Announce  string
+	AddedAt   time.Time
+	// PieceLength is the size of the pieces, and Pieces their number.
+	PieceLength int
+	Pieces      int
+	// Done is set when the wanted pieces are verified, and Running while
+	// the torrent downloads or seeds.
+	Done    bool
+	Running bool
+	// Wanted, Left and Completed are the bytes of the wanted pieces, of
+	// those still missing and of the verified pieces.
+	Wanted     int
+	Left       int
+	Completed  int
+	SeedLimits torrentmeta.SeedLimits
+	Files      []FileStatus
+}
+
+// FileStatus is a file of a torrent.
+type FileStatus struct {
+	// Path is relative to the save path of the torrent.
+	Path      string
+	Length    int
+	Completed int
+	Priority  torrentmeta.Priority
+}
+
+func detailsOf(tf *torrentmeta.TorrentFile) Details {
+	d := Details{
+		Status:      statusOf(tf),
+		Comment:     tf.Comment,
+		CreatedBy:   tf.CreatedBy,
+		Announce:    tf.Announce,
+		AddedAt:     tf.AddedAt,
+		PieceLength: tf.PieceLength,
+		Pieces:      len(tf.PieceHashes),
+		Done:        tf.IsDone,
+		Running:     tf.InProgress,
+		Left:        tf.BytesLeft(),
+		Completed:   tf.BytesCompleted(),
+		SeedLimits:  tf.SeedLimits,
+		Files:       make([]FileStatus, len(tf.Files)),
+	}
+	layout := tf.Layout()
+	for i, p := range tf.PiecePriorities() {
+		if p != torrentmeta.PrioritySkip {
+			d.Wanted += layout.PieceSize(i)
+		}
+	}
+	for i := range tf.Files {
+		d.Files[i] = FileStatus{
+			Path:      tf.RelPath(i),
+			Length:    tf.Files[i].Length,
+			Completed: tf.FileCompleted(i),
+			Priority:  tf.FilePriority(i),
+		}
+	}
+	return d
+}
+
+func (s *Session) detailsOf(h *handle) Details {
+	s.mu.Lock()
+	d := detailsOf(h.tf)
+	s.mu.Unlock()
+	d.SeedGoal = s.goal(h, time.Now())
+	return d
+}
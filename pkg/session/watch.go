@@ -0,0 +1,147 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/watch"
+)
+
+// watchInterval is how often watch folders are rescanned where inotify is
+// not available.
+const watchInterval = 5 * time.Second
+
+// magnetTimeout bounds the metadata fetch of magnet files.
+const magnetTimeout = 5 * time.Minute
+
+// watchRetry is how long a watched file that could not be added for another
+// reason than being invalid waits before it is tried again.
+const watchRetry = time.Minute
+
+const (
+	addedSuffix   = ".added"
+	invalidSuffix = ".invalid"
+)
+
+// watchFolders adds the files dropped into the watch folders until ctx is
+// done.
+func (s *Session) watchFolders(ctx context.Context) {
+	for _, f := range s.cfg.Watch {
+		f := f
+		if err := os.MkdirAll(f.Path, 0770); err != nil {
+			s.log.Error("can't watch folder", "dir", f.Path, "err", err)
+			continue
+		}
+		// pending holds the files being added or waiting to be tried
+		// again.
+		var mu sync.Mutex
+		pending := make(map[string]bool)
+		var handle func(path string)
+		handle = func(path string) {
+			var add func() error
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".torrent":
+				add = func() error { return s.addTorrentFile(f, path) }
+			case ".magnet":
+				add = func() error { return s.addMagnetFile(ctx, f, path) }
+			default:
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if pending[path] {
+				return
+			}
+			pending[path] = true
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				retry := s.ingest(f, path, add())
+				if retry {
+					select {
+					case <-ctx.Done():
+						retry = false
+					case <-time.After(watchRetry):
+					}
+				}
+				mu.Lock()
+				delete(pending, path)
+				mu.Unlock()
+				if _, err := os.Stat(path); retry && err == nil {
+					handle(path)
+				}
+			}()
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			err := watch.Dir(ctx, f.Path, watchInterval, handle)
+			if err != nil {
+				s.log.Error("can't watch folder", "dir", f.Path, "err", err)
+			}
+		}()
+		s.log.Info("watching folder", "dir", f.Path)
+	}
+}
+
+func watchOptions(f WatchFolder) AddOptions {
+	return AddOptions{SavePath: f.SavePath, Label: f.Label, Start: !f.Paused}
+}
+
+func (s *Session) addTorrentFile(f WatchFolder, path string) error {
+	metainfo, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.AddTorrentData(metainfo, watchOptions(f))
+	return err
+}
+
+func (s *Session) addMagnetFile(ctx context.Context, f WatchFolder, path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, magnetTimeout)
+	defer cancel()
+	_, err = s.AddMagnet(ctx, strings.TrimSpace(string(buf)), watchOptions(f))
+	return err
+}
+
+// ingest renames or moves a file of a watch folder once it was handled, so
+// it is not added again. Files that could not be added but are not invalid
+// are left in place, and ingest reports whether they should be tried again.
+func (s *Session) ingest(f WatchFolder, path string, err error) (retry bool) {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	dest := path + addedSuffix
+	switch {
+	case errors.Is(err, ErrExists):
+		s.log.Info("torrent of watched file is already added", "file", path)
+	case errors.Is(err, ErrInvalid):
+		s.log.Warn("invalid watched file", "file", path, "err", err)
+		dest = path + invalidSuffix
+	case err != nil:
+		s.log.Warn("could not add watched file, will try again", "file", path, "in", watchRetry, "err", err)
+		return true
+	default:
+		s.log.Info("added watched file", "file", path)
+	}
+	if err == nil && f.MoveTo != "" {
+		dest = filepath.Join(f.MoveTo, filepath.Base(path))
+		if err := os.MkdirAll(f.MoveTo, 0770); err != nil {
+			s.log.Error("could not move watched file", "file", path, "err", err)
+			return false
+		}
+	}
+	if err := os.Rename(path, dest); err != nil {
+		s.log.Error("could not rename watched file", "file", path, "to", dest, "err", err)
+	}
+	return false
+}
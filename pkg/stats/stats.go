@@ -0,0 +1,235 @@
+// Package stats counts the bytes moved for peers, torrents and sessions.
+package stats
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// smoothing is the weight of the last interval in the rates.
+const smoothing = 0.5
+
+// Counters are byte counts of transfers. Payload is piece data, protocol
+// everything else sent on the connections.
+type Counters struct {
+	Downloaded         int64 `json:"downloaded"`
+	Uploaded           int64 `json:"uploaded"`
+	ProtocolDownloaded int64 `json:"protocol_downloaded"`
+	ProtocolUploaded   int64 `json:"protocol_uploaded"`
+}
+
+func (c Counters) Add(o Counters) Counters {
+	return Counters{
+		Downloaded:         c.Downloaded + o.Downloaded,
+		Uploaded:           c.Uploaded + o.Uploaded,
+		ProtocolDownloaded: c.ProtocolDownloaded + o.ProtocolDownloaded,
+		ProtocolUploaded:   c.ProtocolUploaded + o.ProtocolUploaded,
+	}
+}
+
+// Ratio returns the uploaded payload over the downloaded payload, or 0
+// when nothing was downloaded.
+func Ratio(uploaded, downloaded int64) float64 {
+	if downloaded <= 0 {
+		return 0
+	}
+	return float64(uploaded) / float64(downloaded)
+}
+
+func (c Counters) Ratio() float64 {
+	return Ratio(c.Uploaded, c.Downloaded)
+}
+
+// Stats counts the transfers of a peer, a torrent or a session. What is
+// added to a Stats is added to its parent as well. A nil Stats counts
+// nothing.
+type Stats struct {
+	Addr     string
+	parent   atomic.Pointer[Stats]
+	base     Counters
+	counts   [4]atomic.Int64
+	mu       sync.Mutex
+	last     [2]int64
+	lastTick time.Time
+	rate     [2]float64
+	peers    map[*Stats]struct{}
+}
+
+// Indexes of counts. The payload ones double as indexes of the rates.
+const (
+	payloadDown = iota
+	payloadUp
+	wireDown
+	wireUp
+)
+
+// New returns a Stats whose totals start from base, the counts of earlier
+// runs.
+func New(base Counters) *Stats {
+	return &Stats{base: base, lastTick: time.Now()}
+}
+
+// SetParent makes s add its counts to parent from now on.
+func (s *Stats) SetParent(parent *Stats) {
+	if s != nil {
+		s.parent.Store(parent)
+	}
+}
+
+func (s *Stats) add(i int, n int) {
+	for ; s != nil && n > 0; s = s.parent.Load() {
+		s.counts[i].Add(int64(n))
+	}
+}
+
+// AddDownloaded counts piece data received.
+func (s *Stats) AddDownloaded(n int) { s.add(payloadDown, n) }
+
+// AddUploaded counts piece data sent.
+func (s *Stats) AddUploaded(n int) { s.add(payloadUp, n) }
+
+// AddRead counts bytes read from a connection, piece data included.
+func (s *Stats) AddRead(n int) { s.add(wireDown, n) }
+
+// AddWritten counts bytes written to a connection, piece data included.
+func (s *Stats) AddWritten(n int) { s.add(wireUp, n) }
+
+// Counters returns what was counted since s was created.
+func (s *Stats) Counters() Counters {
+	if s == nil {
+		return Counters{}
+	}
+	down, up := s.counts[payloadDown].Load(), s.counts[payloadUp].Load()
+	return Counters{
+		Downloaded:         down,
+		Uploaded:           up,
+		ProtocolDownloaded: max(s.counts[wireDown].Load()-down, 0),
+		ProtocolUploaded:   max(s.counts[wireUp].Load()-up, 0),
+	}
+}
+
+// Total returns the counts of earlier runs added to Counters.
+func (s *Stats) Total() Counters {
+	if s == nil {
+		return Counters{}
+	}
+	return s.base.Add(s.Counters())
+}
+
+// Rates returns the payload rates in bytes per second, as of the last
+// Tick.
+func (s *Stats) Rates() (download, upload float64) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate[payloadDown], s.rate[payloadUp]
+}
+
+// Tick updates the rates of s and its peers. It is meant to be called
+// about once a second.
+func (s *Stats) Tick(now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	elapsed := now.Sub(s.lastTick).Seconds()
+	if elapsed > 0 {
+		for i := range s.rate {
+			n := s.counts[i].Load()
+			s.rate[i] = smoothing*float64(n-s.last[i])/elapsed + (1-smoothing)*s.rate[i]
+			s.last[i] = n
+		}
+		s.lastTick = now
+	}
+	peers := make([]*Stats, 0, len(s.peers))
+	for p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+	for _, p := range peers {
+		p.Tick(now)
+	}
+}
+
+// AddPeer returns the Stats of a connection to a peer, counting into s.
+func (s *Stats) AddPeer(addr string) *Stats {
+	p := New(Counters{})
+	p.Addr = addr
+	if s == nil {
+		return p
+	}
+	p.SetParent(s)
+	s.mu.Lock()
+	if s.peers == nil {
+		s.peers = make(map[*Stats]struct{})
+	}
+	s.peers[p] = struct{}{}
+	s.mu.Unlock()
+	return p
+}
+
+// RemovePeer forgets a peer once its connection is closed. What it counted
+// stays in s.
+func (s *Stats) RemovePeer(p *Stats) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.peers, p)
+	s.mu.Unlock()
+}
+
+// Peer is the snapshot of a connected peer.
+type Peer struct {
+	Addr string
+	Counters
+	DownloadRate float64
+	UploadRate   float64
+}
+
+// Peers returns the connected peers, sorted by address.
+func (s *Stats) Peers() []Peer {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	list := make([]*Stats, 0, len(s.peers))
+	for p := range s.peers {
+		list = append(list, p)
+	}
+	s.mu.Unlock()
+	peers := make([]Peer, len(list))
+	for i, p := range list {
+		down, up := p.Rates()
+		peers[i] = Peer{Addr: p.Addr, Counters: p.Counters(), DownloadRate: down, UploadRate: up}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Addr < peers[j].Addr })
+	return peers
+}
+
+// Conn counts the bytes moved on a connection.
+type Conn struct {
+	net.Conn
+	stats *Stats
+}
+
+func NewConn(conn net.Conn, s *Stats) *Conn {
+	return &Conn{Conn: conn, stats: s}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.AddRead(n)
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.AddWritten(n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var ErrDiskFull = errors.New("disk full")
+
+// Allocation tells how space for a file is reserved when it is created.
+type Allocation int
+
+const (
+	// AllocateSparse sets the full size of a file without writing it, which
+	// leaves holes on filesystems that support them.
+	AllocateSparse Allocation = iota
+	// AllocateFull reserves all blocks of a file up front.
+	AllocateFull
+	// AllocateNone lets a file grow as pieces are written.
+	AllocateNone
+)
+
+func (a Allocation) String() string {
+	switch a {
+	case AllocateSparse:
+		return "sparse"
+	case AllocateFull:
+		return "full"
+	case AllocateNone:
+		return "none"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(a))
+	}
+}
+
+func ParseAllocation(s string) (Allocation, error) {
+	for _, a := range []Allocation{AllocateSparse, AllocateFull, AllocateNone} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown allocation mode %q", s)
+}
+
+func (a Allocation) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Allocation) UnmarshalText(text []byte) error {
+	parsed, err := ParseAllocation(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func allocate(f *os.File, size int64, mode Allocation) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() >= size {
+		return nil
+	}
+	switch mode {
+	case AllocateFull:
+		err = preallocate(f, size)
+	case AllocateSparse:
+		err = f.Truncate(size)
+	}
+	return diskError(err)
+}
+
+func diskError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %s", ErrDiskFull, err)
+	}
+	return err
+}
+
+// FreeSpace returns the space available to the user on the filesystem of
+// path. Missing parts of the path are skipped, so it can be called before
+// the download directory is created.
+func FreeSpace(path string) (int64, error) {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return freeSpace(path)
+}
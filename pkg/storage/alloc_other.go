@@ -0,0 +1,10 @@
+//go:build !linux
+
+package storage
+
+import "os"
+
+// preallocate falls back to a sparse file where fallocate is not available.
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+type fileStorage struct {
+	layout   Layout
+	alloc    Allocation
+	mu       sync.Mutex
+	handles  []*os.File
+	writable []bool
+	// replaced holds the read-only handles replaced by writable ones. They
+	// are closed with the storage, since reads may still be using them.
+	replaced []*os.File
+}
+
+// NewFile returns a storage that keeps every file of the torrent on disk.
+// Files are opened on first use, and created as sparse files only when they
+// are written to.
+func NewFile(layout Layout) (Storage, error) {
+	return newFileStorage(layout, AllocateSparse), nil
+}
+
+// FileOpener returns an opener of file storages that create files with the
+// given allocation mode.
+func FileOpener(alloc Allocation) Opener {
+	return func(layout Layout) (Storage, error) {
+		return newFileStorage(layout, alloc), nil
+	}
+}
+
+func newFileStorage(layout Layout, alloc Allocation) *fileStorage {
+	return &fileStorage{
+		layout:   layout,
+		alloc:    alloc,
+		handles:  make([]*os.File, len(layout.Files)),
+		writable: make([]bool, len(layout.Files)),
+	}
+}
+
+func (s *fileStorage) handle(index int, write bool) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handles[index] != nil && (s.writable[index] || !write) {
+		return s.handles[index], nil
+	}
+	f := s.layout.Files[index]
+	if !write {
+		h, err := os.Open(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.handles[index] = h
+		return h, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0770); err != nil {
+		return nil, err
+	}
+	h, err := os.OpenFile(f.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := allocate(h, int64(f.Length), s.alloc); err != nil {
+		h.Close()
+		return nil, err
+	}
+	if s.handles[index] != nil {
+		s.replaced = append(s.replaced, s.handles[index])
+	}
+	s.handles[index] = h
+	s.writable[index] = true
+	return h, nil
+}
+
+func (s *fileStorage) readAt(file int, buf []byte, offset int) error {
+	h, err := s.handle(file, false)
+	if err != nil {
+		return err
+	}
+	_, err = h.ReadAt(buf, int64(offset))
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (s *fileStorage) writeAt(file int, buf []byte, offset int) error {
+	h, err := s.handle(file, true)
+	if err != nil {
+		return err
+	}
+	_, err = h.WriteAt(buf, int64(offset))
+	return diskError(err)
+}
+
+func (s *fileStorage) ReadPiece(index int, begin int, buf []byte) error {
+	return s.layout.span(index, begin, len(buf), func(file int, offset int, from int, to int) error {
+		if s.layout.Files[file].Pad {
+			zero(buf[from:to])
+			return nil
+		}
+		if s.layout.Files[file].Skip {
+			return ErrSkippedFile
+		}
+		return s.readAt(file, buf[from:to], offset)
+	})
+}
+
+func (s *fileStorage) WritePiece(index int, begin int, buf []byte) error {
+	return s.layout.span(index, begin, len(buf), func(file int, offset int, from int, to int) error {
+		if s.layout.Files[file].Skip || s.layout.Files[file].Pad {
+			return nil
+		}
+		return s.writeAt(file, buf[from:to], offset)
+	})
+}
+
+func (s *fileStorage) VerifyPiece(index int, hash [utils.PieceHashLen]byte) (bool, error) {
+	return verifyPiece(s, &s.layout, index, hash)
+}
+
+func (s *fileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for i := range s.handles {
+		if s.handles[i] != nil {
+			if cerr := s.handles[i].Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			s.handles[i] = nil
+		}
+	}
+	for _, h := range s.replaced {
+		if cerr := h.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.replaced = nil
+	return err
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplacedHandleStaysOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newFileStorage(Layout{PieceLength: 10, TotalSize: 10, Files: []File{{Path: path, Length: 10}}}, AllocateSparse)
+	r, err := s.handle(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.handle(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w == r {
+		t.Fatal("read-only handle was not replaced for writing")
+	}
+	// A read that got the handle before the upgrade is still going on.
+	buf := make([]byte, 4)
+	if _, err := r.ReadAt(buf, 2); err != nil || string(buf) != "2345" {
+		t.Errorf("read through the replaced handle = %q, %v", buf, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(buf, 0); err == nil {
+		t.Error("replaced handle is still open after Close")
+	}
+}
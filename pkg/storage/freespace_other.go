@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package storage
+
+import "errors"
+
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not known on this platform")
+}
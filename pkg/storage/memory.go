@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+type memoryStorage struct {
+	layout Layout
+	mu     sync.RWMutex
+	data   []byte
+}
+
+// NewMemory returns a storage that keeps the whole torrent in memory. It is
+// meant for tests and small torrents.
+func NewMemory(layout Layout) (Storage, error) {
+	return &memoryStorage{
+		layout: layout,
+		data:   make([]byte, layout.TotalSize),
+	}, nil
+}
+
+func (s *memoryStorage) ReadPiece(index int, begin int, buf []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	base := index*s.layout.PieceLength + begin
+	return s.layout.span(index, begin, len(buf), func(file int, offset int, from int, to int) error {
+		if s.layout.Files[file].Pad {
+			zero(buf[from:to])
+			return nil
+		}
+		if s.layout.Files[file].Skip {
+			return ErrSkippedFile
+		}
+		copy(buf[from:to], s.data[base+from:base+to])
+		return nil
+	})
+}
+
+func (s *memoryStorage) WritePiece(index int, begin int, buf []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base := index*s.layout.PieceLength + begin
+	return s.layout.span(index, begin, len(buf), func(file int, offset int, from int, to int) error {
+		if !s.layout.Files[file].Skip && !s.layout.Files[file].Pad {
+			copy(s.data[base+from:base+to], buf[from:to])
+		}
+		return nil
+	})
+}
+
+func (s *memoryStorage) VerifyPiece(index int, hash [utils.PieceHashLen]byte) (bool, error) {
+	return verifyPiece(s, &s.layout, index, hash)
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,17 @@
+package storage
+
+import "time"
+
+const DefaultWindowSize = 256 << 20
+
+type MmapOptions struct {
+	// WindowSize limits how much of a file is mapped at once. Large files are
+	// mapped in windows of this size as they are accessed.
+	WindowSize int
+	// FlushInterval makes the storage flush dirty windows periodically. When
+	// it is zero data is flushed only by Flush and Close.
+	FlushInterval time.Duration
+	// Allocation is used for files created by the storage. Windows past the
+	// end of a file that is not fully allocated are written without mmap.
+	Allocation Allocation
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+type mmapWindow struct {
+	data     []byte
+	writable bool
+	dirty    bool
+}
+
+type mmapFile struct {
+	windows map[int]*mmapWindow
+	noMap   bool
+}
+
+type mmapStorage struct {
+	*fileStorage
+	window int
+	mu     sync.Mutex
+	files  []mmapFile
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewMmap returns an opener of storages that map the files of a torrent into
+// memory. Files that can not be mapped are accessed like in the file storage.
+func NewMmap(opts MmapOptions) Opener {
+	return func(layout Layout) (Storage, error) {
+		window := opts.WindowSize
+		if window <= 0 {
+			window = DefaultWindowSize
+		}
+		page := os.Getpagesize()
+		window = (window + page - 1) / page * page
+		s := &mmapStorage{
+			fileStorage: newFileStorage(layout, opts.Allocation),
+			window:      window,
+			files:       make([]mmapFile, len(layout.Files)),
+		}
+		for i := range s.files {
+			s.files[i].windows = make(map[int]*mmapWindow)
+		}
+		if opts.FlushInterval > 0 {
+			s.stop = make(chan struct{})
+			s.done = make(chan struct{})
+			go s.flushLoop(opts.FlushInterval)
+		}
+		return s, nil
+	}
+}
+
+func (s *mmapStorage) flushLoop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// mapWindow returns the mapping of the given window of a file, or nil when
+// the file has to be accessed without mmap.
+func (s *mmapStorage) mapWindow(file int, index int, write bool) *mmapWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mf := &s.files[file]
+	if mf.noMap {
+		return nil
+	}
+	if w, ok := mf.windows[index]; ok {
+		if write && !w.writable {
+			return nil
+		}
+		return w
+	}
+	h, err := s.handle(file, write)
+	if err != nil {
+		return nil
+	}
+	s.fileStorage.mu.Lock()
+	writable := s.writable[file]
+	s.fileStorage.mu.Unlock()
+	begin := index * s.window
+	length := s.layout.Files[file].Length - begin
+	if length > s.window {
+		length = s.window
+	}
+	fi, err := h.Stat()
+	if err != nil || fi.Size() < int64(begin+length) {
+		return nil
+	}
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+	data, err := syscall.Mmap(int(h.Fd()), int64(begin), length, prot, syscall.MAP_SHARED)
+	if err != nil {
+		mf.noMap = true
+		return nil
+	}
+	w := &mmapWindow{data: data, writable: writable}
+	mf.windows[index] = w
+	return w
+}
+
+// access calls fn for every window touched by the range of a file, passing
+// nil instead of a window when it can not be mapped.
+func (s *mmapStorage) access(file int, offset int, buf []byte, write bool, fn func(w *mmapWindow, part []byte, offset int) error) error {
+	for len(buf) > 0 {
+		index := offset / s.window
+		inWindow := offset - index*s.window
+		n := s.window - inWindow
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if err := fn(s.mapWindow(file, index, write), buf[:n], inWindow); err != nil {
+			return err
+		}
+		buf = buf[n:]
+		offset += n
+	}
+	return nil
+}
+
+func (s *mmapStorage) ReadPiece(index int, begin int, buf []byte) error {
+	return s.layout.span(index, begin, len(buf), func(file int, offset int, from int, to int) error {
+		if s.layout.Files[file].Pad {
+			zero(buf[from:to])
+			return nil
+		}
+		if s.layout.Files[file].Skip {
+			return ErrSkippedFile
+		}
+		fileOffset := offset
+		return s.access(file, offset, buf[from:to], false, func(w *mmapWindow, part []byte, inWindow int) error {
+			defer func() { fileOffset += len(part) }()
+			if w == nil {
+				return s.fileStorage.readAt(file, part, fileOffset)
+			}
+			copy(part, w.data[inWindow:])
+			return nil
+		})
+	})
+}
+
+func (s *mmapStorage) WritePiece(index int, begin int, buf []byte) error {
+	return s.layout.span(index, begin, len(buf), func(file int, offset int, from int, to int) error {
+		if s.layout.Files[file].Skip || s.layout.Files[file].Pad {
+			return nil
+		}
+		fileOffset := offset
+		return s.access(file, offset, buf[from:to], true, func(w *mmapWindow, part []byte, inWindow int) error {
+			defer func() { fileOffset += len(part) }()
+			if w == nil {
+				return s.fileStorage.writeAt(file, part, fileOffset)
+			}
+			copy(w.data[inWindow:], part)
+			s.mu.Lock()
+			w.dirty = true
+			s.mu.Unlock()
+			return nil
+		})
+	})
+}
+
+func (s *mmapStorage) PieceSlice(index int, begin int, length int) ([]byte, bool) {
+	if index < 0 || begin < 0 || length <= 0 || begin+length > s.layout.PieceSize(index) {
+		return nil, false
+	}
+	var buf []byte
+	err := s.layout.span(index, begin, length, func(file int, offset int, from int, to int) error {
+		if buf != nil || s.layout.Files[file].Skip || s.layout.Files[file].Pad || to-from != length {
+			return ErrSkippedFile
+		}
+		w := s.mapWindow(file, offset/s.window, false)
+		inWindow := offset % s.window
+		if w == nil || inWindow+length > len(w.data) {
+			return ErrSkippedFile
+		}
+		buf = w.data[inWindow : inWindow+length : inWindow+length]
+		return nil
+	})
+	if err != nil || buf == nil {
+		return nil, false
+	}
+	return buf, true
+}
+
+func (s *mmapStorage) VerifyPiece(index int, hash [utils.PieceHashLen]byte) (bool, error) {
+	return verifyPiece(s, &s.layout, index, hash)
+}
+
+func (s *mmapStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for i := range s.files {
+		for _, w := range s.files[i].windows {
+			if !w.dirty {
+				continue
+			}
+			if ferr := msync(w.data); ferr != nil && err == nil {
+				err = ferr
+			}
+			w.dirty = false
+		}
+	}
+	return err
+}
+
+func (s *mmapStorage) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	err := s.Flush()
+	s.mu.Lock()
+	for i := range s.files {
+		for index, w := range s.files[i].windows {
+			if uerr := syscall.Munmap(w.data); uerr != nil && err == nil {
+				err = uerr
+			}
+			delete(s.files[i].windows, index)
+		}
+	}
+	s.mu.Unlock()
+	if cerr := s.fileStorage.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func msync(b []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPieceSlice(t *testing.T) {
+	dir := t.TempDir()
+	layout := testLayout
+	layout.Files = append([]File(nil), testLayout.Files...)
+	for i := range layout.Files {
+		layout.Files[i].Path = filepath.Join(dir, layout.Files[i].Path)
+	}
+	data := make([]byte, layout.TotalSize)
+	for i := range data {
+		data[i] = byte(i + 1)
+	}
+	copy(data[10:14], make([]byte, 4))
+	s, err := NewMmap(MmapOptions{WindowSize: os.Getpagesize()})(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for index := 0; index*layout.PieceLength < layout.TotalSize; index++ {
+		begin := index * layout.PieceLength
+		if err := s.WritePiece(index, 0, data[begin:begin+layout.PieceSize(index)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sr := s.(SliceReader)
+
+	tests := []struct {
+		name                 string
+		index, begin, length int
+		ok                   bool
+	}{
+		{"first piece", 0, 0, 8, true},
+		{"inside a file", 2, 3, 2, true},
+		{"last piece", 3, 0, 6, true},
+		{"over two files", 1, 0, 8, false},
+		{"in padding", 1, 2, 2, false},
+		{"into the next piece", 2, 4, 8, false},
+		{"past the piece", 3, 2, 5, false},
+		{"empty", 0, 0, 0, false},
+		{"negative index", -1, 0, 8, false},
+		{"negative begin", 0, -1, 4, false},
+		{"past the last piece", 4, 0, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, ok := sr.PieceSlice(tt.index, tt.begin, tt.length)
+			if ok != tt.ok {
+				t.Fatalf("PieceSlice(%d, %d, %d) ok = %v, want %v", tt.index, tt.begin, tt.length, ok, tt.ok)
+			}
+			start := tt.index*layout.PieceLength + tt.begin
+			if ok && !bytes.Equal(buf, data[start:start+tt.length]) {
+				t.Errorf("PieceSlice(%d, %d, %d) = %v, want %v", tt.index, tt.begin, tt.length, buf, data[start:start+tt.length])
+			}
+		})
+	}
+
+	layout.Files[0].Skip = true
+	skipped, err := NewMmap(MmapOptions{})(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer skipped.Close()
+	if _, ok := skipped.(SliceReader).PieceSlice(0, 0, 8); ok {
+		t.Error("PieceSlice served a skipped file")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package storage
+
+// NewMmap falls back to the file storage on platforms without mmap support.
+func NewMmap(opts MmapOptions) Opener {
+	return FileOpener(opts.Allocation)
+}
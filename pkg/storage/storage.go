@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+var ErrSkippedFile = errors.New("piece overlaps a skipped file")
+
+type File struct {
+	Path   string
+	Offset int
+	Length int
+	Skip   bool
+	// Pad marks padding files. They are never written and read as zeros.
+	Pad bool
+}
+
+// Layout describes how the pieces of a torrent are laid out over its files.
+type Layout struct {
+	PieceLength int
+	TotalSize   int
+	Files       []File
+}
+
+// Storage keeps the data of a single torrent. Offsets are given relative to
+// the start of a piece, and a buffer may run past the end of the piece into
+// the following ones.
+type Storage interface {
+	ReadPiece(index int, begin int, buf []byte) error
+	WritePiece(index int, begin int, buf []byte) error
+	VerifyPiece(index int, hash [utils.PieceHashLen]byte) (bool, error)
+	Close() error
+}
+
+func zero(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// Opener creates the storage for a torrent with the given layout.
+type Opener func(layout Layout) (Storage, error)
+
+// Flusher is implemented by storages that keep written data in memory until
+// it is flushed.
+type Flusher interface {
+	Flush() error
+}
+
+// SliceReader is implemented by storages that can hand out stored data
+// without copying it. The returned slice must not be modified and is only
+// valid until the storage is closed. ok is false when the range can not be
+// served this way, and ReadPiece should be used instead.
+type SliceReader interface {
+	PieceSlice(index int, begin int, length int) (buf []byte, ok bool)
+}
+
+func (l *Layout) PieceSize(index int) int {
+	begin := index * l.PieceLength
+	end := begin + l.PieceLength
+	if end > l.TotalSize {
+		end = l.TotalSize
+	}
+	return end - begin
+}
+
+// span calls fn for every file touched by the range, passing the file index,
+// the offset inside the file and the matching part of the range.
+func (l *Layout) span(index int, begin int, length int, fn func(file int, offset int, from int, to int) error) error {
+	start := index*l.PieceLength + begin
+	end := start + length
+	if index < 0 || begin < 0 || start > end || end > l.TotalSize {
+		return fmt.Errorf("range %d+%d of piece %d is out of bounds", begin, length, index)
+	}
+	for i := range l.Files {
+		f := l.Files[i]
+		if f.Length == 0 || f.Offset >= end || f.Offset+f.Length <= start {
+			continue
+		}
+		from := f.Offset
+		if start > from {
+			from = start
+		}
+		to := f.Offset + f.Length
+		if end < to {
+			to = end
+		}
+		if err := fn(i, from-f.Offset, from-start, to-start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyPiece(s Storage, l *Layout, index int, hash [utils.PieceHashLen]byte) (bool, error) {
+	buf := make([]byte, l.PieceSize(index))
+	if err := s.ReadPiece(index, 0, buf); err != nil {
+		return false, err
+	}
+	sum := sha1.Sum(buf)
+	return bytes.Equal(sum[:], hash[:]), nil
+}
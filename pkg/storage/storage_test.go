@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testLayout has pieces of 8 bytes over a file of 10 bytes, an empty file, 4
+// bytes of padding and a file of 16 bytes.
+var testLayout = Layout{
+	PieceLength: 8,
+	TotalSize:   30,
+	Files: []File{
+		{Path: "a", Offset: 0, Length: 10},
+		{Path: "empty", Offset: 10, Length: 0},
+		{Path: "pad", Offset: 10, Length: 4, Pad: true},
+		{Path: "c", Offset: 14, Length: 16},
+	},
+}
+
+func TestPieceSize(t *testing.T) {
+	for index, want := range []int{8, 8, 8, 6} {
+		if got := testLayout.PieceSize(index); got != want {
+			t.Errorf("PieceSize(%d) = %d, want %d", index, got, want)
+		}
+	}
+}
+
+type spanPart struct {
+	file, offset, from, to int
+}
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		name                 string
+		index, begin, length int
+		want                 []spanPart
+		fail                 bool
+	}{
+		{"first piece", 0, 0, 8, []spanPart{{0, 0, 0, 8}}, false},
+		{"over padding", 1, 0, 8, []spanPart{{0, 8, 0, 2}, {2, 0, 2, 6}, {3, 0, 6, 8}}, false},
+		{"inside a piece", 2, 3, 2, []spanPart{{3, 5, 0, 2}}, false},
+		{"into the next piece", 2, 4, 8, []spanPart{{3, 6, 0, 8}}, false},
+		{"last piece", 3, 0, 6, []spanPart{{3, 10, 0, 6}}, false},
+		{"empty range", 1, 2, 0, nil, false},
+		{"negative index", -1, 0, 8, nil, true},
+		{"negative begin", 1, -1, 4, nil, true},
+		{"negative length", 1, 4, -1, nil, true},
+		{"past the end", 3, 0, 7, nil, true},
+		{"past the last piece", 4, 0, 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []spanPart
+			err := testLayout.span(tt.index, tt.begin, tt.length, func(file int, offset int, from int, to int) error {
+				got = append(got, spanPart{file, offset, from, to})
+				return nil
+			})
+			if (err != nil) != tt.fail {
+				t.Fatalf("span(%d, %d, %d) error = %v, want failure %v", tt.index, tt.begin, tt.length, err, tt.fail)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("span(%d, %d, %d) = %v, want %v", tt.index, tt.begin, tt.length, got, tt.want)
+			}
+		})
+	}
+}
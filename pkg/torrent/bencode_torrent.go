@@ -1,24 +1,26 @@
 package torrent
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/DanArmor/GoTorrent/pkg/utils"
-	"github.com/jackpal/bencode-go"
 )
 
 type bencodeInfoV1 struct {
-	Files       []File `bencode:"files,omitempty"`
-	Name        string `bencode:"name"`
-	NameUtf8    string `bencode:"name.utf-8,omitempty"`
-	PieceLength int    `bencode:"piece length"`
-	Pieces      string `bencode:"pieces"`
-	Source      string `bencode:"source,omitempty"`
-	Length      int    `bencode:"length,omitempty"`
-	Private     *bool  `bencode:"private,omitempty"`
+	Files       []File   `bencode:"files,omitempty"`
+	Name        string   `bencode:"name"`
+	NameUtf8    string   `bencode:"name.utf-8,omitempty"`
+	PieceLength int      `bencode:"piece length"`
+	Pieces      string   `bencode:"pieces"`
+	Source      string   `bencode:"source,omitempty"`
+	Length      int      `bencode:"length,omitempty"`
+	Private     *bool    `bencode:"private,omitempty"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+	Sha1        string   `bencode:"sha1,omitempty"`
 }
 
 type bencodeTorrentV1 struct {
@@ -28,16 +30,6 @@ type bencodeTorrentV1 struct {
 	Comment   string        `bencode:"comment,omitempty"`
 }
 
-func (bi *bencodeInfoV1) hash() ([utils.InfoHashLen]byte, error) {
-	var buf bytes.Buffer
-	err := bencode.Marshal(&buf, *bi)
-	if err != nil {
-		return [20]byte{}, err
-	}
-	h := sha1.Sum(buf.Bytes())
-	return h, nil
-}
-
 func (bi *bencodeInfoV1) splitPieceHashes() ([][utils.PieceHashLen]byte, error) {
 	buf := []byte(bi.Pieces)
 	if len(buf)%utils.PieceHashLen != 0 {
@@ -61,44 +53,98 @@ func (bt *bencodeTorrentV1) calculateFilesBounds() int {
 	return totalSize
 }
 
-func (bt *bencodeTorrentV1) calculateFullPaths() {
-	for i := range bt.Info.Files {
-		bt.Info.Files[i].FullPath = filepath.Join(bt.Info.Files[i].Path...)
+// name returns the name of the torrent, preferring name.utf-8.
+func (bi *bencodeInfoV1) name() string {
+	if bi.NameUtf8 != "" {
+		return bi.NameUtf8
 	}
+	return bi.Name
 }
 
-func (bt *bencodeTorrentV1) toTorrentFile() (TorrentFile, error) {
-	infoHash, err := bt.Info.hash()
-	if err != nil {
-		return TorrentFile{}, nil
+// filePath returns the path of the file, preferring path.utf-8.
+func (f *File) filePath() []string {
+	if len(f.PathUtf8) != 0 {
+		return f.PathUtf8
+	}
+	return f.Path
+}
+
+// calculateFullPaths validates the file paths and joins them. Paths of
+// multi-file torrents are placed under the torrent name.
+func (bt *bencodeTorrentV1) calculateFullPaths(name string, isMultiple bool) error {
+	if err := checkPath([]string{name}); err != nil {
+		return err
+	}
+	paths := make([][]string, len(bt.Info.Files))
+	var stored [][]string
+	for i := range bt.Info.Files {
+		paths[i] = bt.Info.Files[i].filePath()
+		if err := checkPath(paths[i]); err != nil {
+			return err
+		}
+		if bt.Info.Files[i].IsSymlink() {
+			if err := checkPath(bt.Info.Files[i].SymlinkPath); err != nil {
+				return err
+			}
+		}
+		if bt.Info.Files[i].Sha1 != "" && len(bt.Info.Files[i].Sha1) != utils.PieceHashLen {
+			return fmt.Errorf("malformed sha1 of file %q", strings.Join(paths[i], "/"))
+		}
+		if !bt.Info.Files[i].IsPadding() {
+			stored = append(stored, paths[i])
+		}
 	}
+	if err := checkCollisions(stored); err != nil {
+		return err
+	}
+	for i := range bt.Info.Files {
+		bt.Info.Files[i].FullPath = filepath.Join(paths[i]...)
+		if isMultiple {
+			bt.Info.Files[i].FullPath = filepath.Join(name, bt.Info.Files[i].FullPath)
+		}
+	}
+	return nil
+}
+
+func (bt *bencodeTorrentV1) toTorrentFile(info []byte) (TorrentFile, error) {
+	infoHash := sha1.Sum(info)
 	pieceHashes, err := bt.Info.splitPieceHashes()
 	if err != nil {
-		return TorrentFile{}, nil
+		return TorrentFile{}, err
 	}
+	name := bt.Info.name()
 	isMultiple := bt.Info.Length == 0
 	if !isMultiple {
-		bt.Info.Files = append(bt.Info.Files, File{Length: bt.Info.Length, Path: []string{bt.Info.Name}})
+		bt.Info.Files = append(bt.Info.Files, File{
+			Length:      bt.Info.Length,
+			Path:        []string{name},
+			Attr:        bt.Info.Attr,
+			SymlinkPath: bt.Info.SymlinkPath,
+			Sha1:        bt.Info.Sha1,
+		})
 	}
 	totalSize := bt.calculateFilesBounds()
-	bt.calculateFullPaths()
+	if bt.Info.PieceLength <= 0 {
+		return TorrentFile{}, fmt.Errorf("invalid piece length %d", bt.Info.PieceLength)
+	}
+	if want := (totalSize + bt.Info.PieceLength - 1) / bt.Info.PieceLength; len(pieceHashes) != want {
+		return TorrentFile{}, fmt.Errorf("%d pieces for %d bytes in pieces of %d bytes, want %d", len(pieceHashes), totalSize, bt.Info.PieceLength, want)
+	}
+	if err := bt.calculateFullPaths(name, isMultiple); err != nil {
+		return TorrentFile{}, err
+	}
 	t := TorrentFile{
 		Announce:    bt.Announce,
 		InfoHash:    infoHash,
 		PieceHashes: pieceHashes,
 		PieceLength: bt.Info.PieceLength,
 		Length:      bt.Info.Length,
-		Name:        bt.Info.Name,
+		Name:        name,
 		Files:       bt.Info.Files,
 		TotalSize:   totalSize,
 		IsMultiple:  isMultiple,
 		CreatedBy:   bt.CreatedBy,
 		Comment:     bt.Comment,
 	}
-	if isMultiple {
-		for i := range t.Files {
-			t.Files[i].FullPath = filepath.Join(t.Name, t.Files[i].FullPath)
-		}
-	}
 	return t, nil
 }
@@ -0,0 +1,111 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxNameLength is the longest file or folder name accepted, in bytes.
+const MaxNameLength = 255
+
+var (
+	ErrEmptyPath     = errors.New("empty path or name")
+	ErrAbsolutePath  = errors.New("absolute path")
+	ErrPathTraversal = errors.New("path traversal")
+	ErrPathSeparator = errors.New("path separator in name")
+	ErrNulByte       = errors.New("NUL byte in name")
+	ErrReservedName  = errors.New("reserved name")
+	ErrNameTooLong   = errors.New("name too long")
+	ErrDuplicatePath = errors.New("duplicate or colliding path")
+)
+
+// PathError is returned by Parse for a file path that can not be stored
+// safely. Err is one of the errors above.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("invalid path %q: %s", e.Path, e.Err)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// reservedNames can not be used as file names on Windows, with or without an
+// extension.
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+func isAbsolute(name string) bool {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return true
+	}
+	return len(name) >= 2 && name[1] == ':'
+}
+
+func checkName(name string) error {
+	switch {
+	case name == "":
+		return ErrEmptyPath
+	case strings.ContainsRune(name, 0):
+		return ErrNulByte
+	case name == "." || name == "..":
+		return ErrPathTraversal
+	case isAbsolute(name):
+		return ErrAbsolutePath
+	case strings.ContainsAny(name, "/\\"):
+		return ErrPathSeparator
+	case len(name) > MaxNameLength:
+		return ErrNameTooLong
+	}
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToLower(strings.TrimRight(base, " "))] {
+		return ErrReservedName
+	}
+	return nil
+}
+
+func checkPath(elems []string) error {
+	if len(elems) == 0 {
+		return &PathError{Err: ErrEmptyPath}
+	}
+	for _, name := range elems {
+		if err := checkName(name); err != nil {
+			return &PathError{Path: strings.Join(elems, "/"), Err: err}
+		}
+	}
+	return nil
+}
+
+// checkCollisions rejects files with the same path and files whose path is
+// also a folder of another file. Paths are compared ignoring case, as they
+// would collide on case-insensitive filesystems.
+func checkCollisions(paths [][]string) error {
+	files := make(map[string]bool)
+	dirs := make(map[string]string)
+	for _, elems := range paths {
+		key := strings.ToLower(strings.Join(elems, "/"))
+		if _, ok := dirs[key]; ok || files[key] {
+			return &PathError{Path: strings.Join(elems, "/"), Err: ErrDuplicatePath}
+		}
+		files[key] = true
+		for i := 1; i < len(elems); i++ {
+			dirs[strings.ToLower(strings.Join(elems[:i], "/"))] = strings.Join(elems, "/")
+		}
+	}
+	for dir, path := range dirs {
+		if files[dir] {
+			return &PathError{Path: path, Err: ErrDuplicatePath}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackpal/bencode-go"
+)
+
+var ErrNoInfo = errors.New("metainfo has no info dictionary")
+
+// rawInfo returns the info dictionary of the metainfo exactly as it was
+// encoded. The info hash must be computed over these bytes, since decoding
+// and encoding again drops keys that are not known.
+func rawInfo(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, errors.New("metainfo is not a dictionary")
+	}
+	i := 1
+	for i < len(data) && data[i] != 'e' {
+		keyEnd, err := skipValue(data, i)
+		if err != nil {
+			return nil, err
+		}
+		key := data[i:keyEnd]
+		end, err := skipValue(data, keyEnd)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(key, []byte("4:info")) {
+			return data[keyEnd:end], nil
+		}
+		i = end
+	}
+	return nil, ErrNoInfo
+}
+
+// skipValue returns the offset right after the bencoded value at i.
+func skipValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, errors.New("unexpected end of metainfo")
+	}
+	switch c := data[i]; {
+	case c == 'i':
+		end := bytes.IndexByte(data[i:], 'e')
+		if end < 0 {
+			return 0, fmt.Errorf("unterminated integer at %d", i)
+		}
+		return i + end + 1, nil
+	case c == 'l' || c == 'd':
+		i++
+		for i < len(data) && data[i] != 'e' {
+			next, err := skipValue(data, i)
+			if err != nil {
+				return 0, err
+			}
+			i = next
+		}
+		if i >= len(data) {
+			return 0, errors.New("unterminated list or dictionary")
+		}
+		return i + 1, nil
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(data[i:], ':')
+		if colon < 0 {
+			return 0, fmt.Errorf("malformed string at %d", i)
+		}
+		n, err := strconv.Atoi(string(data[i : i+colon]))
+		if err != nil || n < 0 || i+colon+1+n > len(data) {
+			return 0, fmt.Errorf("malformed string at %d", i)
+		}
+		return i + colon + 1 + n, nil
+	default:
+		return 0, fmt.Errorf("unexpected %q at %d", c, i)
+	}
+}
+
+// Encode builds metainfo from the parsed torrent. Keys of the original
+// that are not kept by Parse are lost, so the info hash of the result may
+// differ from InfoHash.
+func (t *TorrentFile) Encode() ([]byte, error) {
+	bt := bencodeTorrentV1{
+		Announce:  t.Announce,
+		CreatedBy: t.CreatedBy,
+		Comment:   t.Comment,
+		Info: bencodeInfoV1{
+			Name:        t.Name,
+			PieceLength: t.PieceLength,
+		},
+	}
+	var pieces bytes.Buffer
+	for i := range t.PieceHashes {
+		pieces.Write(t.PieceHashes[i][:])
+	}
+	bt.Info.Pieces = pieces.String()
+	if t.IsMultiple {
+		bt.Info.Files = t.Files
+	} else if len(t.Files) == 1 {
+		bt.Info.Length = t.Files[0].Length
+		bt.Info.Attr = t.Files[0].Attr
+		bt.Info.SymlinkPath = t.Files[0].SymlinkPath
+		bt.Info.Sha1 = t.Files[0].Sha1
+	}
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, bt); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
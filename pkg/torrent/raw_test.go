@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// knownInfo has keys the parser does not know, which must stay in the info
+// hash.
+const knownInfo = "d6:lengthi100e4:name8:file.bin12:piece lengthi16384e6:pieces20:pppppppppppppppppppp6:source4:test7:x-extra3:abce"
+
+const knownInfoHash = "c8e3c08a39fce6923605916891f8fdaf2a5e9544"
+
+const knownMetainfo = "d8:announce28:http://tracker.test/announce13:creation datei1700000000e4:info" + knownInfo + "8:url-listl19:http://seed.test/a/ee"
+
+func TestRawInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		metainfo string
+		info     string
+		fail     bool
+	}{
+		{"known torrent", knownMetainfo, knownInfo, false},
+		{"info first", "d4:info" + knownInfo + "e", knownInfo, false},
+		{"nested info key", "d3:keyd4:infoi1ee4:infod4:name1:aee", "d4:name1:ae", false},
+		{"empty", "", "", true},
+		{"not a dictionary", "l4:infoe", "", true},
+		{"no info", "d8:announce3:urle", "", true},
+		{"truncated", knownMetainfo[:60], "", true},
+		{"string past the end", "d4:info9:abce", "", true},
+		{"bad integer", "d1:ai12", "", true},
+		{"bad value", "d1:ax4:infode", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := rawInfo([]byte(tt.metainfo))
+			if (err != nil) != tt.fail {
+				t.Fatalf("rawInfo error = %v, want failure %v", err, tt.fail)
+			}
+			if !bytes.Equal(info, []byte(tt.info)) {
+				t.Errorf("rawInfo = %q, want %q", info, tt.info)
+			}
+		})
+	}
+}
+
+func TestParseBytesInfoHash(t *testing.T) {
+	tf, err := ParseBytes([]byte(knownMetainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(tf.InfoHash[:]); got != knownInfoHash {
+		t.Errorf("info hash = %s, want %s", got, knownInfoHash)
+	}
+	if sum := sha1.Sum([]byte(knownInfo)); hex.EncodeToString(sum[:]) != knownInfoHash {
+		t.Fatal("knownInfoHash is not the hash of knownInfo")
+	}
+	if tf.Name != "file.bin" || tf.Length != 100 || tf.Announce != "http://tracker.test/announce" {
+		t.Errorf("parsed %q of %d bytes from %q", tf.Name, tf.Length, tf.Announce)
+	}
+}
+
+func TestParseBytesPieces(t *testing.T) {
+	hashes := func(n int) string {
+		return fmt.Sprintf("6:pieces%d:%s", 20*n, strings.Repeat("p", 20*n))
+	}
+	tests := []struct {
+		name string
+		info string
+		fail bool
+	}{
+		{"one piece", "d6:lengthi100e4:name1:a12:piece lengthi16384e" + hashes(1) + "e", false},
+		{"last piece full", "d6:lengthi32768e4:name1:a12:piece lengthi16384e" + hashes(2) + "e", false},
+		{"empty", "d5:filesle4:name1:a12:piece lengthi16384e" + hashes(0) + "e", false},
+		{"zero piece length", "d6:lengthi100e4:name1:a12:piece lengthi0e" + hashes(1) + "e", true},
+		{"negative piece length", "d6:lengthi100e4:name1:a12:piece lengthi-16384e" + hashes(1) + "e", true},
+		{"missing piece", "d6:lengthi16385e4:name1:a12:piece lengthi16384e" + hashes(1) + "e", true},
+		{"extra piece", "d6:lengthi100e4:name1:a12:piece lengthi16384e" + hashes(2) + "e", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBytes([]byte("d4:info" + tt.info + "e"))
+			if (err != nil) != tt.fail {
+				t.Errorf("ParseBytes error = %v, want failure %v", err, tt.fail)
+			}
+		})
+	}
+}
@@ -1,18 +1,44 @@
 package torrent
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/DanArmor/GoTorrent/pkg/utils"
 	"github.com/jackpal/bencode-go"
 	"os"
+	"strings"
 )
 
 type File struct {
-	Length   int      `bencode:"length"`
-	Path     []string `bencode:"path"`
-	PathUtf8 []string `bencode:"path.utf-8,omitempty"`
-	FullPath string   `bencode:"-"`
-	Begin    int      `bencode:"-"`
-	End      int      `bencode:"-"`
+	Length      int      `bencode:"length"`
+	Path        []string `bencode:"path"`
+	PathUtf8    []string `bencode:"path.utf-8,omitempty"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+	Sha1        string   `bencode:"sha1,omitempty"`
+	FullPath    string   `bencode:"-"`
+	Begin       int      `bencode:"-"`
+	End         int      `bencode:"-"`
+}
+
+// IsPadding reports whether the file only aligns the next file to a piece
+// boundary (BEP 47). Padding files hold zeros and are never stored.
+func (f *File) IsPadding() bool {
+	return strings.ContainsRune(f.Attr, 'p')
+}
+
+func (f *File) IsExecutable() bool {
+	return strings.ContainsRune(f.Attr, 'x')
+}
+
+func (f *File) IsHidden() bool {
+	return strings.ContainsRune(f.Attr, 'h')
+}
+
+// IsSymlink reports whether the file is a link to SymlinkPath, relative to
+// the root of the torrent.
+func (f *File) IsSymlink() bool {
+	return strings.ContainsRune(f.Attr, 'l')
 }
 
 type TorrentFile struct {
@@ -30,19 +56,33 @@ type TorrentFile struct {
 }
 
 func Parse(path string) (TorrentFile, error) {
-	file, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return TorrentFile{}, err
 	}
-	defer file.Close()
+	return ParseBytes(data)
+}
 
+// ParseBytes parses metainfo. The info hash is computed over the info
+// dictionary as it is encoded in data.
+func ParseBytes(data []byte) (tf TorrentFile, err error) {
+	// bencode panics on values of unexpected types.
+	defer func() {
+		if r := recover(); r != nil {
+			tf, err = TorrentFile{}, fmt.Errorf("malformed metainfo: %v", r)
+		}
+	}()
+	info, err := rawInfo(data)
+	if err != nil {
+		return TorrentFile{}, err
+	}
 	bt := bencodeTorrentV1{}
-	err = bencode.Unmarshal(file, &bt)
+	err = bencode.Unmarshal(bytes.NewReader(data), &bt)
 	if err != nil {
 		return TorrentFile{}, err
 	}
 
-	tf, err := bt.toTorrentFile()
+	tf, err = bt.toTorrentFile(info)
 	if err != nil {
 		return TorrentFile{}, err
 	}
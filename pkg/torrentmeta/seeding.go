@@ -0,0 +1,119 @@
+package torrentmeta
+
+import (
+	"fmt"
+	"time"
+)
+
+// SeedAction is what is done with a torrent that reached a seeding limit.
+type SeedAction string
+
+const (
+	SeedPause      SeedAction = "pause"
+	SeedRemove     SeedAction = "remove"
+	SeedRemoveData SeedAction = "remove_data"
+)
+
+// ParseSeedAction parses the name of an action. An empty name is left for
+// the global action.
+func ParseSeedAction(s string) (SeedAction, error) {
+	switch a := SeedAction(s); a {
+	case "", SeedPause, SeedRemove, SeedRemoveData:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown seeding action %q", s)
+}
+
+// SeedLimits stop seeding once the share ratio, the time spent seeding or
+// the time without uploads reaches them. Times are in minutes. For the
+// limits of a torrent, zero uses the global limit and a negative value
+// disables it; globally, zero or less is no limit.
+type SeedLimits struct {
+	Ratio    float64    `json:"ratio,omitempty"`
+	SeedTime int        `json:"seed_time,omitempty"`
+	IdleTime int        `json:"idle_time,omitempty"`
+	Action   SeedAction `json:"action,omitempty"`
+}
+
+// Over returns the limits of l, taking those of global where l has none.
+// Disabled limits are zero in the result.
+func (l SeedLimits) Over(global SeedLimits) SeedLimits {
+	eff := global
+	if l.Ratio != 0 {
+		eff.Ratio = l.Ratio
+	}
+	if l.SeedTime != 0 {
+		eff.SeedTime = l.SeedTime
+	}
+	if l.IdleTime != 0 {
+		eff.IdleTime = l.IdleTime
+	}
+	if l.Action != "" {
+		eff.Action = l.Action
+	}
+	eff.Ratio = max(eff.Ratio, 0)
+	eff.SeedTime = max(eff.SeedTime, 0)
+	eff.IdleTime = max(eff.IdleTime, 0)
+	if eff.Action == "" {
+		eff.Action = SeedPause
+	}
+	return eff
+}
+
+// SeedGoal is what is left before a torrent reaches its seeding limits.
+// The fields of disabled limits are zero.
+type SeedGoal struct {
+	Limits       SeedLimits
+	Ratio        float64
+	SeedTimeLeft time.Duration
+	IdleTimeLeft time.Duration
+	// Reached names the first limit reached, if any.
+	Reached string
+}
+
+// Limited reports whether any seeding limit applies.
+func (g SeedGoal) Limited() bool {
+	return g.Limits.Ratio > 0 || g.Limits.SeedTime > 0 || g.Limits.IdleTime > 0
+}
+
+func (g SeedGoal) String() string {
+	if !g.Limited() {
+		return "none"
+	}
+	if g.Reached != "" {
+		return fmt.Sprintf("%s reached, %s", g.Reached, g.Limits.Action)
+	}
+	s := ""
+	if g.Limits.Ratio > 0 {
+		s += fmt.Sprintf("ratio %.2f/%.2f, ", g.Ratio, g.Limits.Ratio)
+	}
+	if g.Limits.SeedTime > 0 {
+		s += fmt.Sprintf("%s of seeding left, ", g.SeedTimeLeft.Round(time.Minute))
+	}
+	if g.Limits.IdleTime > 0 {
+		s += fmt.Sprintf("%s until idle, ", g.IdleTimeLeft.Round(time.Second))
+	}
+	return s + "then " + string(g.Limits.Action)
+}
+
+// SeedGoal returns the state of the seeding limits of the torrent, given
+// the global limits and how long it has not uploaded.
+func (tf *TorrentFile) SeedGoal(global SeedLimits, idle time.Duration) SeedGoal {
+	g := SeedGoal{Limits: tf.SeedLimits.Over(global), Ratio: tf.Ratio()}
+	if g.Limits.Ratio > 0 && g.Ratio >= g.Limits.Ratio {
+		g.Reached = "ratio"
+	}
+	if g.Limits.SeedTime > 0 {
+		g.SeedTimeLeft = max(time.Duration(g.Limits.SeedTime)*time.Minute-tf.SeedTime, 0)
+		if g.SeedTimeLeft == 0 && g.Reached == "" {
+			g.Reached = "seed time"
+		}
+	}
+	if g.Limits.IdleTime > 0 {
+		g.IdleTimeLeft = max(time.Duration(g.Limits.IdleTime)*time.Minute-idle, 0)
+		if g.IdleTimeLeft == 0 && g.Reached == "" {
+			g.Reached = "idle time"
+		}
+	}
+	return g
+}
@@ -0,0 +1,236 @@
+package torrentmeta
+
+import (
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/bitfield"
+	"github.com/DanArmor/GoTorrent/pkg/recheck"
+	"github.com/DanArmor/GoTorrent/pkg/resume"
+	"github.com/DanArmor/GoTorrent/pkg/stats"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
+	"github.com/DanArmor/GoTorrent/pkg/torrent"
+	"github.com/DanArmor/GoTorrent/pkg/utils"
+)
+
+// StateVersion is the version of the state files written by Save. Version 1
+// counted pieces in its stats, which are dropped on load.
+const StateVersion = 2
+
+const (
+	stateExt    = ".json"
+	metainfoExt = ".torrent"
+)
+
+// state is what is kept of a torrent between runs, next to its original
+// metainfo.
+type state struct {
+	Version  int            `json:"version"`
+	InfoHash string         `json:"info_hash"`
+	Name     string         `json:"name"`
+	AddedAt  time.Time      `json:"added_at"`
+	Settings settings       `json:"settings"`
+	Stats    stats.Counters `json:"stats"`
+	// SeedSeconds is the time seeded once complete.
+	SeedSeconds int64 `json:"seed_seconds,omitempty"`
+	// Bitfield holds the pieces known to be valid.
+	Bitfield bitfield.Bitfield `json:"bitfield"`
+	IsDone   bool              `json:"done"`
+	Error    string            `json:"error,omitempty"`
+	DiskFull bool              `json:"disk_full,omitempty"`
+	Recheck  *recheck.State    `json:"recheck,omitempty"`
+	Resume   *resume.Data      `json:"resume,omitempty"`
+}
+
+type settings struct {
+	SavePath      string         `json:"save_path"`
+	Priorities    []Priority     `json:"priorities"`
+	Renamed       map[int]string `json:"renamed,omitempty"`
+	DownloadLimit int            `json:"download_limit,omitempty"`
+	UploadLimit   int            `json:"upload_limit,omitempty"`
+	SeedLimits    SeedLimits     `json:"seed_limits"`
+	QueuePosition int            `json:"queue_position"`
+	Label         string         `json:"label,omitempty"`
+}
+
+// StatePath returns the path of the state file of the torrent in dir.
+func StatePath(dir string, hash [utils.InfoHashLen]byte) string {
+	return filepath.Join(dir, hex.EncodeToString(hash[:])+stateExt)
+}
+
+func metainfoPath(statePath string) string {
+	return strings.TrimSuffix(statePath, stateExt) + metainfoExt
+}
+
+// IsStateFile reports whether name is the name of a state file.
+func IsStateFile(name string) bool {
+	return strings.HasSuffix(name, stateExt)
+}
+
+// Save writes the state of the torrent to dir, keyed by its info hash. The
+// metainfo is written the first time only.
+func (tf *TorrentFile) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+	path := StatePath(dir, tf.InfoHash)
+	if _, err := os.Stat(metainfoPath(path)); err != nil {
+		if err := storage.WriteFile(metainfoPath(path), tf.metainfo, 0644); err != nil {
+			return err
+		}
+	}
+	st := state{
+		Version:  StateVersion,
+		InfoHash: hex.EncodeToString(tf.InfoHash[:]),
+		Name:     tf.Name,
+		AddedAt:  tf.AddedAt,
+		Settings: settings{
+			SavePath:      tf.SavePath,
+			Priorities:    tf.Priorities,
+			Renamed:       tf.Renamed,
+			DownloadLimit: tf.DownloadLimit,
+			UploadLimit:   tf.UploadLimit,
+			SeedLimits:    tf.SeedLimits,
+			QueuePosition: tf.QueuePosition,
+			Label:         tf.Label,
+		},
+		Stats:       tf.stats.Total(),
+		SeedSeconds: int64(tf.SeedTime / time.Second),
+		Bitfield:    tf.Bitfield,
+		IsDone:      tf.IsDone,
+		Error:       tf.Error,
+		DiskFull:    tf.DiskFull,
+		Recheck:     tf.RecheckState,
+		Resume:      tf.Resume,
+	}
+	buf, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(path, append(buf, '\n'), 0644)
+}
+
+// Load reads a torrent from the state file at path and its metainfo.
+func Load(path string) (*TorrentFile, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st state
+	if err := json.Unmarshal(buf, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if st.Version > StateVersion {
+		return nil, fmt.Errorf("%s: unsupported state version %d", path, st.Version)
+	}
+	metainfo, err := os.ReadFile(metainfoPath(path))
+	if err != nil {
+		return nil, err
+	}
+	t, err := torrent.ParseBytes(metainfo)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", metainfoPath(path), err)
+	}
+	// Metainfo rebuilt from gob state may miss keys of the original info
+	// dictionary, so the hash the torrent was added with wins.
+	if hash, err := hex.DecodeString(st.InfoHash); err == nil && len(hash) == utils.InfoHashLen {
+		copy(t.InfoHash[:], hash)
+	}
+	tf := &TorrentFile{
+		TorrentFile:   t,
+		metainfo:      metainfo,
+		AddedAt:       st.AddedAt,
+		SavePath:      st.Settings.SavePath,
+		Priorities:    st.Settings.Priorities,
+		Renamed:       st.Settings.Renamed,
+		DownloadLimit: st.Settings.DownloadLimit,
+		UploadLimit:   st.Settings.UploadLimit,
+		SeedLimits:    st.Settings.SeedLimits,
+		QueuePosition: st.Settings.QueuePosition,
+		Label:         st.Settings.Label,
+		SeedTime:      time.Duration(st.SeedSeconds) * time.Second,
+		Bitfield:      st.Bitfield,
+		IsDone:        st.IsDone,
+		Error:         st.Error,
+		DiskFull:      st.DiskFull,
+		RecheckState:  st.Recheck,
+		Resume:        st.Resume,
+	}
+	if st.Version >= 2 {
+		tf.stats = stats.New(st.Stats)
+	}
+	if len(tf.Bitfield) != len(tf.PieceHashes)/8+1 {
+		tf.Bitfield = make(bitfield.Bitfield, len(tf.PieceHashes)/8+1)
+		tf.Resume = nil
+	}
+	for i := range tf.Files {
+		rel, ok := tf.Renamed[i]
+		if !ok {
+			rel = tf.Files[i].FullPath
+		}
+		tf.Files[i].FullPath = filepath.Join(tf.SavePath, rel)
+	}
+	tf.init()
+	return tf, nil
+}
+
+// init sets up what is not saved.
+func (tf *TorrentFile) init() {
+	for len(tf.Priorities) < len(tf.Files) {
+		tf.Priorities = append(tf.Priorities, PriorityNormal)
+	}
+	if tf.stats == nil {
+		tf.stats = stats.New(stats.Counters{})
+	}
+	tf.stateMu = &sync.Mutex{}
+	tf.Done = make(chan struct{}, 1)
+	tf.Out = make(chan struct{})
+	tf.Count = make(chan int)
+}
+
+// RemoveState removes the state and the metainfo of the torrent from dir.
+func RemoveState(dir string, hash [utils.InfoHashLen]byte) error {
+	path := StatePath(dir, hash)
+	err := os.Remove(path)
+	if merr := os.Remove(metainfoPath(path)); err == nil {
+		err = merr
+	}
+	return err
+}
+
+// LoadGob reads a torrent saved by earlier versions as a gob file. The
+// metainfo is rebuilt from the parsed torrent.
+func LoadGob(path string) (*TorrentFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tf := &TorrentFile{}
+	if err := gob.NewDecoder(f).Decode(tf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if tf.SavePath == "" && len(tf.Files) > 0 {
+		rel := filepath.Join(tf.Files[0].Path...)
+		if tf.IsMultiple {
+			rel = filepath.Join(tf.Name, rel)
+		}
+		tf.SavePath = filepath.Clean(strings.TrimSuffix(tf.Files[0].FullPath, rel))
+	}
+	if tf.metainfo, err = tf.Encode(); err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil {
+		tf.AddedAt = fi.ModTime()
+	}
+	tf.InProgress = false
+	tf.init()
+	return tf, nil
+}
@@ -2,20 +2,32 @@ package torrentmeta
 
 import (
 	"bytes"
-	"crypto/rand"
+	"context"
 	"crypto/sha1"
-	"encoding/gob"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/DanArmor/GoTorrent/pkg/bitfield"
+	"github.com/DanArmor/GoTorrent/pkg/diskio"
+	"github.com/DanArmor/GoTorrent/pkg/event"
+	"github.com/DanArmor/GoTorrent/pkg/metrics"
 	"github.com/DanArmor/GoTorrent/pkg/p2p"
 	"github.com/DanArmor/GoTorrent/pkg/peers"
+	"github.com/DanArmor/GoTorrent/pkg/ratelimit"
+	"github.com/DanArmor/GoTorrent/pkg/recheck"
+	"github.com/DanArmor/GoTorrent/pkg/resume"
+	"github.com/DanArmor/GoTorrent/pkg/stats"
+	"github.com/DanArmor/GoTorrent/pkg/storage"
 	"github.com/DanArmor/GoTorrent/pkg/torrent"
 	"github.com/DanArmor/GoTorrent/pkg/utils"
 	"github.com/jackpal/bencode-go"
@@ -23,67 +35,124 @@ import (
 
 const Port uint16 = 36010
 
+var (
+	announces       = metrics.Default.CounterVec("gotorrent_tracker_announces_total", "Announces to trackers by result.", "info_hash", "result")
+	announceLatency = metrics.Default.Histogram("gotorrent_tracker_announce_duration_seconds", "Time taken by announces to trackers.", metrics.DurationBuckets)
+)
+
 type bencodeTrackerRespCompact struct {
 	Interval int    `bencode:"interval"`
 	Peers    string `bencode:"peers"`
 	Peers6   string `bencode:"peers6,omitempty"`
 }
 
-type TorrentFile struct {
-	torrent.TorrentFile
-	Bitfield   bitfield.Bitfield
-	Downloaded int
-	Uploaded   int
-	Done       chan struct{}
-	Count      chan int
-	Out        chan struct{}
-	InProgress bool
-	IsDone     bool
-}
-
-func New(path string, downloadPath string) TorrentFile {
-	tf, err := torrent.Parse(path)
-	if err != nil {
-		panic(err)
-	}
-	tfm := TorrentFile{
-		TorrentFile: tf,
-	}
-	tfm.Bitfield = make(bitfield.Bitfield, len(tfm.PieceHashes)/8+1)
-	for i := range tfm.Files {
-		tfm.Files[i].FullPath = filepath.Join(downloadPath, tfm.Files[i].FullPath)
+type Priority int
+
+const (
+	PrioritySkip Priority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PrioritySkip:
+		return "Skip"
+	case PriorityLow:
+		return "Low"
+	case PriorityNormal:
+		return "Normal"
+	case PriorityHigh:
+		return "High"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(p))
 	}
-	tfm.Done = make(chan struct{})
-	tfm.Out = make(chan struct{})
-	tfm.Count = make(chan int)
-	return tfm
 }
 
-func (tf *TorrentFile) Save(path string) {
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		panic(err)
+// ParsePriority parses the name of a priority, ignoring case.
+func ParsePriority(s string) (Priority, error) {
+	for p := PrioritySkip; p <= PriorityHigh; p++ {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
 	}
-	err = gob.NewEncoder(f).Encode(tf)
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
+
+type TorrentFile struct {
+	torrent.TorrentFile
+	Bitfield     bitfield.Bitfield
+	Priorities   []Priority
+	Done         chan struct{}
+	Count        chan int
+	Out          chan struct{}
+	InProgress   bool
+	IsDone       bool
+	RecheckState *recheck.State
+	Resume       *resume.Data
+	Error        string
+	DiskFull     bool
+	// SavePath is the directory the files of the torrent are stored under.
+	SavePath string
+	// Renamed maps file indexes to the paths, relative to SavePath, the
+	// files were renamed to.
+	Renamed map[int]string
+	AddedAt time.Time
+	// DownloadLimit and UploadLimit are in bytes per second. Zero means no
+	// limit.
+	DownloadLimit int
+	UploadLimit   int
+	// SeedLimits are the seeding limits of the torrent, over the global
+	// ones.
+	SeedLimits SeedLimits
+	// SeedTime is how long the torrent has seeded once complete.
+	SeedTime time.Duration
+	// Queued is set while the torrent waits for a free slot of the queue,
+	// and QueuePosition is its place in the queue.
+	Queued        bool
+	QueuePosition int
+	// Label groups torrents for the user.
+	Label string
+	// metainfo is the torrent file the torrent was added from.
+	metainfo    []byte
+	stats       *stats.Stats
+	disk        *diskio.Disk
+	stateMu     *sync.Mutex
+	checkJob    *recheck.Job
+	checkCancel context.CancelFunc
+	moving      bool
+}
+
+func New(path string, downloadPath string) (*TorrentFile, error) {
+	metainfo, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	f.Close()
+	return NewBytes(metainfo, downloadPath)
 }
 
-func (tf *TorrentFile) Load(path string) {
-	f, err := os.Open(path)
+// NewBytes creates a torrent from metainfo, storing its files under
+// downloadPath.
+func NewBytes(metainfo []byte, downloadPath string) (*TorrentFile, error) {
+	tf, err := torrent.ParseBytes(metainfo)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	err = gob.NewDecoder(f).Decode(tf)
-	if err != nil {
-		panic(err)
+	tfm := &TorrentFile{
+		TorrentFile: tf,
+		metainfo:    metainfo,
+		AddedAt:     time.Now(),
+	}
+	tfm.Bitfield = make(bitfield.Bitfield, len(tfm.PieceHashes)/8+1)
+	tfm.Priorities = make([]Priority, len(tfm.Files))
+	for i := range tfm.Files {
+		tfm.Files[i].FullPath = filepath.Join(downloadPath, tfm.Files[i].FullPath)
+		tfm.Priorities[i] = PriorityNormal
 	}
-	tf.Done = make(chan struct{})
-	tf.Out = make(chan struct{})
-	tf.Count = make(chan int)
-	f.Close()
+	tfm.SavePath = downloadPath
+	tfm.init()
+	return tfm, nil
 }
 
 func (tf *TorrentFile) BuildTrackerURL(peerID [20]byte, port uint16) (string, error) {
@@ -91,20 +160,34 @@ func (tf *TorrentFile) BuildTrackerURL(peerID [20]byte, port uint16) (string, er
 	if err != nil {
 		return "", err
 	}
+	total := tf.stats.Total()
 	params := url.Values{
 		"info_hash":  []string{string(tf.InfoHash[:])},
 		"peer_id":    []string{string(peerID[:])},
 		"port":       []string{strconv.Itoa(int(port))},
-		"uploaded":   []string{strconv.Itoa(tf.Uploaded)},
-		"downloaded": []string{strconv.Itoa(tf.Downloaded)},
+		"uploaded":   []string{strconv.FormatInt(total.Uploaded, 10)},
+		"downloaded": []string{strconv.FormatInt(total.Downloaded, 10)},
 		"compact":    []string{"1"},
-		"left":       []string{strconv.Itoa(len(tf.PieceHashes) - tf.Downloaded)},
+		"left":       []string{strconv.Itoa(tf.BytesLeft())},
 	}
 	base.RawQuery = params.Encode()
 	return base.String(), nil
 }
 
-func (tf *TorrentFile) requestPeers(peerID [utils.PeerIDLen]byte, port uint16) ([]peers.Peer, error) {
+// RequestPeers announces the torrent to its tracker and returns the peers.
+func (tf *TorrentFile) RequestPeers(peerID [utils.PeerIDLen]byte, port uint16) ([]peers.Peer, error) {
+	start := time.Now()
+	list, err := tf.announce(peerID, port)
+	announceLatency.Observe(time.Since(start).Seconds())
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	announces.With(metrics.InfoHash(tf.InfoHash), result).Inc()
+	return list, err
+}
+
+func (tf *TorrentFile) announce(peerID [utils.PeerIDLen]byte, port uint16) ([]peers.Peer, error) {
 	trackerUrl, err := tf.BuildTrackerURL(peerID, port)
 	if err != nil {
 		return nil, err
@@ -121,101 +204,409 @@ func (tf *TorrentFile) requestPeers(peerID [utils.PeerIDLen]byte, port uint16) (
 	if err != nil {
 		return nil, err
 	}
-	if len([]byte(trackerResp.Peers)) == 0{
+	if len([]byte(trackerResp.Peers)) == 0 {
 		return peers.Unmarshal([]byte(trackerResp.Peers6), false)
-	} else{
+	} else {
 		return peers.Unmarshal([]byte(trackerResp.Peers), true)
 	}
 }
 
-func (t *TorrentFile) calculateBoundsForPiece(index int) (begin int, end int) {
-	begin = index * t.PieceLength
-	end = begin + t.PieceLength
-	if end > t.Files[len(t.Files)-1].End {
-		end = t.Files[len(t.Files)-1].End
+func (t *TorrentFile) FilePriority(index int) Priority {
+	if index < 0 || index >= len(t.Priorities) {
+		return PriorityNormal
 	}
-	return begin, end
+	return t.Priorities[index]
 }
 
-func (t *TorrentFile) calculatePieceSize(index int) int {
-	begin, end := t.calculateBoundsForPiece(index)
-	return end - begin
+// SetFilePriority changes the priority of a file. The bytes of a skipped
+// file are not written, so the pieces it shares with other files may miss
+// them: a recheck of the pieces of a file that leaves the skip state is
+// prepared in RecheckState.
+func (t *TorrentFile) SetFilePriority(index int, p Priority) {
+	for len(t.Priorities) < len(t.Files) {
+		t.Priorities = append(t.Priorities, PriorityNormal)
+	}
+	if t.Priorities[index] == PrioritySkip && p != PrioritySkip {
+		t.recheckPieces(t.filePieces(index))
+	}
+	t.Priorities[index] = p
+	t.IsDone = t.Completed()
 }
 
-func (t *TorrentFile) CheckFilesIntegrity() bool {
-	var err error
-	fileIndex := 0
-	handlers := make([]*os.File, len(t.Files))
-	for i := range handlers {
-		handlers[i], err = os.Open(t.Files[i].FullPath)
-		if err != nil {
-			panic(err)
+// recheckPieces adds the pieces we have among pieces to the pending
+// recheck, preparing one that keeps the other pieces if there is none.
+func (t *TorrentFile) recheckPieces(pieces []int) {
+	state := t.RecheckState
+	if state == nil {
+		state = recheck.NewState(len(t.PieceHashes))
+		for i := range t.PieceHashes {
+			state.Checked.SetPiece(i)
+			if t.Bitfield.HasPiece(i) {
+				state.Have.SetPiece(i)
+			}
 		}
-		defer func(index int) {
-			handlers[index].Close()
-		}(i)
 	}
-	for i := range t.PieceHashes {
-		buf := make([]byte, t.calculatePieceSize(i))
-		n, err := handlers[fileIndex].Read(buf)
-		if err != nil {
-			if err != io.EOF {
-				panic(err)
-			} else {
-				fileIndex++
+	queued := false
+	for _, piece := range pieces {
+		if t.Bitfield.HasPiece(piece) {
+			state.Checked.ClearPiece(piece)
+			state.Have.ClearPiece(piece)
+			queued = true
+		}
+	}
+	if queued {
+		t.RecheckState = state
+	}
+}
+
+func (t *TorrentFile) filePieces(index int) []int {
+	f := t.Files[index]
+	if f.Length == 0 || t.PieceLength == 0 {
+		return nil
+	}
+	var pieces []int
+	for i := f.Begin / t.PieceLength; i <= (f.End-1)/t.PieceLength && i < len(t.PieceHashes); i++ {
+		pieces = append(pieces, i)
+	}
+	return pieces
+}
+
+// FileCompleted returns how many bytes of the file are in verified pieces.
+func (t *TorrentFile) FileCompleted(index int) int {
+	f := t.Files[index]
+	done := 0
+	for _, piece := range t.filePieces(index) {
+		if t.Bitfield.HasPiece(piece) {
+			done += min((piece+1)*t.PieceLength, f.End) - max(piece*t.PieceLength, f.Begin)
+		}
+	}
+	return done
+}
+
+// PiecePriorities returns the priority of every piece, which is the highest
+// priority among the files the piece overlaps.
+func (t *TorrentFile) PiecePriorities() []Priority {
+	prios := make([]Priority, len(t.PieceHashes))
+	for i := range t.Files {
+		p := t.FilePriority(i)
+		for _, piece := range t.filePieces(i) {
+			if p > prios[piece] {
+				prios[piece] = p
 			}
 		}
-		if n != len(buf) {
-			p2p.WriteToLog("hello")
-			for {
-				if fileIndex == len(t.Files) {
-					return t.CheckIntegrity(t.PieceHashes[i], buf)
-				}
-				r, err := handlers[fileIndex].Read(buf[n+1:])
-				if err != nil {
-					if err != io.EOF {
-						panic(err)
-					} else {
-						fileIndex++
-					}
-				}
-				n += r
-				if n == len(buf) {
-					break
-				}
+	}
+	return prios
+}
+
+// SeedBitfield returns the pieces that can be served, leaving out pieces
+// that overlap skipped files.
+func (t *TorrentFile) SeedBitfield() bitfield.Bitfield {
+	bf := make(bitfield.Bitfield, len(t.Bitfield))
+	copy(bf, t.Bitfield)
+	for i := range t.Files {
+		if t.FilePriority(i) == PrioritySkip {
+			for _, piece := range t.filePieces(i) {
+				bf.ClearPiece(piece)
 			}
 		}
-		if !t.CheckIntegrity(t.PieceHashes[i], buf) {
+	}
+	return bf
+}
+
+func (t *TorrentFile) Completed() bool {
+	for i, p := range t.PiecePriorities() {
+		if p != PrioritySkip && !t.Bitfield.HasPiece(i) {
 			return false
 		}
 	}
 	return true
 }
 
-func (t *TorrentFile) CheckIntegrity(pw [utils.PieceHashLen]byte, buf []byte) bool {
-	hash := sha1.Sum(buf)
-	return bytes.Equal(hash[:], pw[:])
+func (t *TorrentFile) Progress() float64 {
+	wanted, have := 0, 0
+	for i, p := range t.PiecePriorities() {
+		if p != PrioritySkip {
+			wanted++
+			if t.Bitfield.HasPiece(i) {
+				have++
+			}
+		}
+	}
+	if wanted == 0 {
+		return 1
+	}
+	return float64(have) / float64(wanted)
+}
+
+func (t *TorrentFile) Layout() storage.Layout {
+	layout := storage.Layout{
+		PieceLength: t.PieceLength,
+		TotalSize:   t.TotalSize,
+	}
+	for i := range t.Files {
+		layout.Files = append(layout.Files, storage.File{
+			Path:   t.Files[i].FullPath,
+			Offset: t.Files[i].Begin,
+			Length: t.Files[i].Length,
+			Skip:   t.FilePriority(i) == PrioritySkip,
+			Pad:    t.Files[i].IsPadding(),
+		})
+	}
+	return layout
 }
 
-func (tf *TorrentFile) DownloadToFile() error {
-	var peerID [utils.PeerIDLen]byte
-	_, err := rand.Read(peerID[:])
+// Recheck hashes the data on disk and replaces the bitfield with the pieces
+// found valid. An interrupted recheck is saved in RecheckState and continued
+// by the next call.
+func (t *TorrentFile) Recheck(ctx context.Context, open storage.Opener) error {
+	st, err := open(t.Layout())
 	if err != nil {
 		return err
 	}
-	peers, err := tf.requestPeers(peerID, Port)
+	defer st.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	job := recheck.New(st, t.PieceHashes, t.RecheckState, 0)
+	t.stateMu.Lock()
+	t.checkJob = job
+	t.checkCancel = cancel
+	t.stateMu.Unlock()
+	defer func() {
+		t.stateMu.Lock()
+		t.checkJob = nil
+		t.checkCancel = nil
+		t.stateMu.Unlock()
+		cancel()
+	}()
+	bf, err := job.Run(ctx)
 	if err != nil {
+		t.RecheckState = job.State()
 		return err
 	}
+	t.RecheckState = nil
+	t.Bitfield = bf
+	t.IsDone = t.Completed()
+	t.CaptureResume()
+	return nil
+}
+
+func (t *TorrentFile) CancelRecheck() {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	if t.checkCancel != nil {
+		t.checkCancel()
+	}
+}
+
+func (t *TorrentFile) filePaths() []string {
+	paths := make([]string, len(t.Files))
+	for i := range t.Files {
+		paths[i] = t.Files[i].FullPath
+	}
+	return paths
+}
+
+// CaptureResume remembers the state of the files together with the pieces
+// known to be valid. It should be called once the storage is closed.
+func (t *TorrentFile) CaptureResume() {
+	var blocks map[int]bitfield.Bitfield
+	if t.Resume != nil {
+		blocks = t.Resume.Blocks
+	}
+	t.Resume = resume.Capture(t.filePaths(), t.Bitfield, blocks)
+}
+
+// ValidateResume compares the resume data with the files on disk. Pieces of
+// files that changed are dropped and a recheck of only these pieces is
+// prepared in RecheckState. It reports whether a recheck is needed.
+func (t *TorrentFile) ValidateResume() bool {
+	if t.RecheckState != nil {
+		return true
+	}
+	if t.Resume == nil {
+		t.RecheckState = recheck.NewState(len(t.PieceHashes))
+		return true
+	}
+	changed := t.Resume.Changed(t.filePaths())
+	if len(t.Resume.Bitfield) == len(t.Bitfield) {
+		copy(t.Bitfield, t.Resume.Bitfield)
+	}
+	t.IsDone = t.Completed()
+	if len(changed) == 0 {
+		return false
+	}
+	affected := make(map[int]bool)
+	for _, i := range changed {
+		for _, piece := range t.filePieces(i) {
+			affected[piece] = true
+		}
+	}
+	state := recheck.NewState(len(t.PieceHashes))
+	for i := range t.PieceHashes {
+		if affected[i] {
+			t.Bitfield.ClearPiece(i)
+			delete(t.Resume.Blocks, i)
+			continue
+		}
+		state.Checked.SetPiece(i)
+		if t.Bitfield.HasPiece(i) {
+			state.Have.SetPiece(i)
+		}
+	}
+	t.RecheckState = state
+	return true
+}
+
+// Checking reports whether a recheck is running and how far it got.
+func (t *TorrentFile) Checking() (bool, float64) {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	if t.checkJob == nil {
+		return false, 0
+	}
+	checked, total := t.checkJob.Progress()
+	if total == 0 {
+		return true, 1
+	}
+	return true, float64(checked) / float64(total)
+}
+
+func (t *TorrentFile) CheckIntegrity(pw [utils.PieceHashLen]byte, buf []byte) bool {
+	hash := sha1.Sum(buf)
+	return bytes.Equal(hash[:], pw[:])
+}
+
+// SetError records why the torrent was paused. A nil error clears it.
+func (tf *TorrentFile) SetError(err error) {
+	if err == nil {
+		tf.Error = ""
+		tf.DiskFull = false
+		return
+	}
+	tf.Error = err.Error()
+	tf.DiskFull = errors.Is(err, storage.ErrDiskFull)
+}
+
+// BytesLeft returns how many bytes of the wanted pieces are still missing.
+func (tf *TorrentFile) BytesLeft() int {
+	left := 0
+	layout := tf.Layout()
+	for i, p := range tf.PiecePriorities() {
+		if p != PrioritySkip && !tf.Bitfield.HasPiece(i) {
+			left += layout.PieceSize(i)
+		}
+	}
+	return left
+}
+
+// BytesCompleted returns the size of the verified pieces.
+func (tf *TorrentFile) BytesCompleted() int {
+	done := 0
+	layout := tf.Layout()
+	for i := range tf.PieceHashes {
+		if tf.Bitfield.HasPiece(i) {
+			done += layout.PieceSize(i)
+		}
+	}
+	return done
+}
 
-	var p2pFiles []p2p.File
+// Ratio returns the uploaded payload over the downloaded payload. A torrent
+// added with its data counts the verified bytes as downloaded.
+func (tf *TorrentFile) Ratio() float64 {
+	total := tf.stats.Total()
+	if total.Downloaded == 0 {
+		return stats.Ratio(total.Uploaded, int64(tf.BytesCompleted()))
+	}
+	return total.Ratio()
+}
 
+// SpaceNeeded estimates how much more disk space the download takes with the
+// given allocation mode. Fully allocated files already hold their space.
+func (tf *TorrentFile) SpaceNeeded(alloc storage.Allocation) int {
+	if alloc != storage.AllocateFull {
+		return tf.BytesLeft()
+	}
+	needed := 0
 	for i := range tf.Files {
-		f, err := os.OpenFile(tf.Files[i].FullPath, os.O_RDWR, 0644)
-		if err != nil {
-			panic(err)
+		if tf.FilePriority(i) == PrioritySkip || tf.Files[i].IsPadding() {
+			continue
+		}
+		size := 0
+		if fi, err := os.Stat(tf.Files[i].FullPath); err == nil {
+			size = int(fi.Size())
+		}
+		if size < tf.Files[i].Length {
+			needed += tf.Files[i].Length - size
 		}
-		p2pFiles = append(p2pFiles, p2p.File{File: tf.Files[i], Handler: f})
+	}
+	return needed
+}
+
+// CheckFreeSpace returns storage.ErrDiskFull when the filesystem of the
+// download can not hold the rest of it.
+func (tf *TorrentFile) CheckFreeSpace(alloc storage.Allocation) error {
+	if len(tf.Files) == 0 {
+		return nil
+	}
+	free, err := storage.FreeSpace(filepath.Dir(tf.Files[0].FullPath))
+	if err != nil {
+		return nil
+	}
+	if needed := tf.SpaceNeeded(alloc); int64(needed) > free {
+		return fmt.Errorf("%w: %d bytes needed, %d bytes free", storage.ErrDiskFull, needed, free)
+	}
+	return nil
+}
+
+// OpenDisk opens the storage of the torrent and attaches it to the pool. The
+// disk is shared by the download and all upload connections until CloseDisk.
+func (tf *TorrentFile) OpenDisk(pool *diskio.Pool, open storage.Opener) error {
+	if tf.disk != nil {
+		return nil
+	}
+	layout := tf.Layout()
+	st, err := open(layout)
+	if err != nil {
+		return err
+	}
+	tf.disk = pool.Open(st, layout)
+	return nil
+}
+
+func (tf *TorrentFile) Disk() *diskio.Disk {
+	return tf.disk
+}
+
+// Stats returns the transfer counters of the torrent, including those of
+// earlier runs in their totals.
+func (tf *TorrentFile) Stats() *stats.Stats {
+	return tf.stats
+}
+
+func (tf *TorrentFile) CloseDisk() error {
+	if tf.disk == nil {
+		return nil
+	}
+	err := tf.disk.Close()
+	tf.disk = nil
+	return err
+}
+
+func (tf *TorrentFile) DownloadToFile(peerID [utils.PeerIDLen]byte, port uint16, logger *slog.Logger, events *event.Bus, limiters []*ratelimit.Limiter) error {
+	if tf.disk == nil {
+		return fmt.Errorf("storage of <%s> is not open", tf.Name)
+	}
+	peers, err := tf.RequestPeers(peerID, port)
+	if err != nil {
+		events.Publish(event.Event{Type: event.TrackerError, InfoHash: tf.InfoHash, Message: err.Error()})
+		return err
+	}
+	events.Publish(event.Event{Type: event.TrackerAnnounced, InfoHash: tf.InfoHash, Message: tf.Announce})
+
+	piecePriorities := tf.PiecePriorities()
+	priorities := make([]int, len(piecePriorities))
+	for i := range piecePriorities {
+		priorities[i] = int(piecePriorities[i])
 	}
 
 	torrent := p2p.Torrent{
@@ -224,22 +615,312 @@ func (tf *TorrentFile) DownloadToFile() error {
 		InfoHash:    tf.InfoHash,
 		PieceHashes: tf.PieceHashes,
 		PieceLength: tf.PieceLength,
-		Files:       p2pFiles,
 		Name:        tf.Name,
 		Length:      tf.Length,
+		TotalSize:   tf.TotalSize,
+		Disk:        tf.disk,
+		Logger:      logger,
+		Events:      events,
+		Limiters:    limiters,
+		Stats:       tf.stats,
 		Bitfield:    tf.Bitfield,
+		Priorities:  priorities,
+	}
+	if tf.Resume != nil {
+		torrent.Blocks = make(map[int]bitfield.Bitfield, len(tf.Resume.Blocks))
+		for index, blocks := range tf.Resume.Blocks {
+			torrent.Blocks[index] = append(bitfield.Bitfield(nil), blocks...)
+		}
 	}
 
+	errc := make(chan error, 1)
 	go func() {
-		torrent.Download(tf.Done, tf.Count)
+		errc <- torrent.Download(tf.Done, tf.Count)
 	}()
 
 	for index := range tf.Count {
-		tf.Downloaded++
 		tf.Bitfield.SetPiece(index)
 	}
-	if tf.Downloaded != len(tf.PieceHashes) {
-		tf.Out <- struct{}{}
+	if tf.Resume == nil {
+		tf.Resume = &resume.Data{}
+	}
+	tf.Resume.Blocks = torrent.Blocks
+	return <-errc
+}
+
+// Moving reports whether the files of the torrent are being moved.
+func (tf *TorrentFile) Moving() bool {
+	tf.stateMu.Lock()
+	defer tf.stateMu.Unlock()
+	return tf.moving
+}
+
+// Move moves the files of the torrent under dir. The torrent must be stopped.
+// Files that were moved are put back when a later one fails, so the paths in
+// the metadata always match the disk.
+func (tf *TorrentFile) Move(dir string) error {
+	dir = filepath.Clean(dir)
+	if dir == filepath.Clean(tf.SavePath) {
+		return nil
+	}
+	tf.stateMu.Lock()
+	if tf.moving {
+		tf.stateMu.Unlock()
+		return fmt.Errorf("<%s> is already being moved", tf.Name)
+	}
+	tf.moving = true
+	tf.stateMu.Unlock()
+	defer func() {
+		tf.stateMu.Lock()
+		tf.moving = false
+		tf.stateMu.Unlock()
+	}()
+
+	paths := make([]string, len(tf.Files))
+	var moved []int
+	for i := range tf.Files {
+		rel, err := filepath.Rel(tf.SavePath, tf.Files[i].FullPath)
+		if err != nil {
+			return err
+		}
+		paths[i] = filepath.Join(dir, rel)
+		if _, err := os.Lstat(tf.Files[i].FullPath); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if _, err := os.Lstat(paths[i]); err == nil {
+			err = fmt.Errorf("can not move %s: %s already exists", tf.Files[i].FullPath, paths[i])
+			tf.undoMove(dir, paths, moved)
+			return err
+		}
+		if err := storage.MoveFile(tf.Files[i].FullPath, paths[i]); err != nil {
+			tf.undoMove(dir, paths, moved)
+			return err
+		}
+		moved = append(moved, i)
+	}
+	for i := range tf.Files {
+		storage.RemoveEmptyDirs(filepath.Dir(tf.Files[i].FullPath), tf.SavePath)
+		tf.Files[i].FullPath = paths[i]
+	}
+	tf.SavePath = dir
+	tf.CaptureResume()
+	return nil
+}
+
+func (tf *TorrentFile) undoMove(dir string, paths []string, moved []int) {
+	for _, i := range moved {
+		storage.MoveFile(paths[i], tf.Files[i].FullPath)
+		storage.RemoveEmptyDirs(filepath.Dir(paths[i]), dir)
+	}
+}
+
+// RelPath returns the path of the file relative to SavePath.
+func (tf *TorrentFile) RelPath(index int) string {
+	rel, err := filepath.Rel(tf.SavePath, tf.Files[index].FullPath)
+	if err != nil {
+		return tf.Files[index].FullPath
+	}
+	return rel
+}
+
+// RootName returns the folder a multi-file torrent is stored in.
+func (tf *TorrentFile) RootName() string {
+	if !tf.IsMultiple || len(tf.Files) == 0 {
+		return ""
+	}
+	return strings.Split(filepath.ToSlash(tf.RelPath(0)), "/")[0]
+}
+
+func validRelPath(name string) error {
+	clean := filepath.Clean(name)
+	if name == "" || clean == "." {
+		return fmt.Errorf("empty file name")
+	}
+	if filepath.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%q is outside of the save path", name)
+	}
+	if strings.ContainsRune(name, 0) {
+		return fmt.Errorf("%q contains a NUL byte", name)
+	}
+	return nil
+}
+
+// RenameFile moves file index to the path name, relative to SavePath. The
+// file is renamed on disk when it exists. The torrent must be stopped.
+func (tf *TorrentFile) RenameFile(index int, name string) error {
+	if err := validRelPath(name); err != nil {
+		return err
+	}
+	return tf.rename(map[int]string{index: filepath.Clean(name)})
+}
+
+// RenameRoot renames the folder of a multi-file torrent. Files that were
+// moved out of the folder are left alone. The torrent must be stopped.
+func (tf *TorrentFile) RenameRoot(name string) error {
+	if !tf.IsMultiple {
+		return fmt.Errorf("<%s> has no root folder", tf.Name)
+	}
+	if err := validRelPath(name); err != nil {
+		return err
+	}
+	if strings.ContainsRune(filepath.ToSlash(filepath.Clean(name)), '/') {
+		return fmt.Errorf("%q is not a single folder name", name)
+	}
+	root := tf.RootName()
+	paths := make(map[int]string)
+	for i := range tf.Files {
+		rel := tf.RelPath(i)
+		if strings.HasPrefix(rel, root+string(filepath.Separator)) {
+			paths[i] = filepath.Join(name, strings.TrimPrefix(rel, root))
+		}
+	}
+	return tf.rename(paths)
+}
+
+// rename gives the files the new relative paths and renames them on disk.
+// When a rename fails, the files renamed so far are put back.
+func (tf *TorrentFile) rename(paths map[int]string) error {
+	taken := make(map[string]bool)
+	for i := range tf.Files {
+		if _, ok := paths[i]; !ok {
+			taken[tf.RelPath(i)] = true
+		}
+	}
+	for _, rel := range paths {
+		if taken[rel] {
+			return fmt.Errorf("%q is already used by another file", rel)
+		}
+		taken[rel] = true
+	}
+	var done []int
+	undo := func() {
+		for _, i := range done {
+			storage.MoveFile(filepath.Join(tf.SavePath, paths[i]), tf.Files[i].FullPath)
+			storage.RemoveEmptyDirs(filepath.Dir(filepath.Join(tf.SavePath, paths[i])), tf.SavePath)
+		}
+	}
+	for i, rel := range paths {
+		dst := filepath.Join(tf.SavePath, rel)
+		if dst == tf.Files[i].FullPath {
+			continue
+		}
+		if _, err := os.Lstat(tf.Files[i].FullPath); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			undo()
+			return fmt.Errorf("can not rename %s: %s already exists", tf.Files[i].FullPath, dst)
+		}
+		if err := storage.MoveFile(tf.Files[i].FullPath, dst); err != nil {
+			undo()
+			return err
+		}
+		done = append(done, i)
+	}
+	if tf.Renamed == nil {
+		tf.Renamed = make(map[int]string)
+	}
+	for i, rel := range paths {
+		storage.RemoveEmptyDirs(filepath.Dir(tf.Files[i].FullPath), tf.SavePath)
+		tf.Files[i].FullPath = filepath.Join(tf.SavePath, rel)
+		tf.Renamed[i] = rel
+	}
+	tf.CaptureResume()
+	return nil
+}
+
+// rootPath returns the directory symlink targets are relative to.
+func (tf *TorrentFile) rootPath() string {
+	if tf.IsMultiple {
+		return filepath.Join(tf.SavePath, tf.RootName())
+	}
+	return tf.SavePath
+}
+
+// FinalizeFiles applies the file attributes once the download is complete:
+// executable files get their exec bits, symlinks are created, and files with
+// a sha1 in the metainfo are verified against it. The pieces of files that
+// do not match are dropped, so they are downloaded again.
+func (tf *TorrentFile) FinalizeFiles() error {
+	var errs []error
+	dropped := false
+	for i := range tf.Files {
+		f := &tf.Files[i]
+		if tf.FilePriority(i) == PrioritySkip || f.IsPadding() {
+			continue
+		}
+		if f.IsSymlink() {
+			if err := tf.createSymlink(f); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if f.IsExecutable() {
+			if fi, err := os.Stat(f.FullPath); err != nil {
+				errs = append(errs, err)
+			} else if err := os.Chmod(f.FullPath, fi.Mode().Perm()|0111); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if f.Sha1 != "" {
+			if err := verifyFile(f); err != nil {
+				errs = append(errs, err)
+				for _, piece := range tf.filePieces(i) {
+					tf.Bitfield.ClearPiece(piece)
+				}
+				dropped = true
+			}
+		}
+	}
+	if dropped {
+		tf.IsDone = tf.Completed()
+		tf.CaptureResume()
+	}
+	return errors.Join(errs...)
+}
+
+func (tf *TorrentFile) createSymlink(f *torrent.File) error {
+	target := filepath.Join(tf.rootPath(), filepath.Join(f.SymlinkPath...))
+	rel, err := filepath.Rel(filepath.Dir(f.FullPath), target)
+	if err != nil {
+		return err
+	}
+	if current, err := os.Readlink(f.FullPath); err == nil && current == rel {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(f.FullPath), 0770); err != nil {
+		return err
+	}
+	os.Remove(f.FullPath)
+	return os.Symlink(rel, f.FullPath)
+}
+
+func verifyFile(f *torrent.File) error {
+	file, err := os.Open(f.FullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), []byte(f.Sha1)) {
+		return fmt.Errorf("%s does not match its sha1", f.FullPath)
 	}
 	return nil
 }
+
+// RemoveFiles deletes the files of the torrent and the folders left empty.
+func (tf *TorrentFile) RemoveFiles() error {
+	var errs []error
+	for i := range tf.Files {
+		err := os.Remove(tf.Files[i].FullPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		storage.RemoveEmptyDirs(filepath.Dir(tf.Files[i].FullPath), tf.SavePath)
+	}
+	return errors.Join(errs...)
+}
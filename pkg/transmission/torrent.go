@@ -0,0 +1,450 @@
+package transmission
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/session"
+	"github.com/DanArmor/GoTorrent/pkg/torrentmeta"
+)
+
+// Status values of torrent-get.
+const (
+	statusStopped      = 0
+	statusCheckWait    = 1
+	statusCheck        = 2
+	statusDownloadWait = 3
+	statusDownload     = 4
+	statusSeedWait     = 5
+	statusSeed         = 6
+)
+
+// errorLocal is the error value of torrent-get for local errors.
+const errorLocal = 3
+
+// speedUnit is the number of bytes in the KB of speed limits.
+const speedUnit = 1000
+
+// torrent is what the fields of torrent-get are computed from.
+type torrent struct {
+	id  int
+	pos int
+	st  session.Details
+}
+
+// eta returns the seconds left until the download is done, or -1 when it
+// is not downloading.
+func (t *torrent) eta() int {
+	if t.st.State != session.StateDownloading || t.st.DownloadRate <= 0 {
+		return -1
+	}
+	return t.st.Left / t.st.DownloadRate
+}
+
+func (t *torrent) status() int {
+	switch t.st.State {
+	case session.StateChecking:
+		return statusCheck
+	case session.StateDownloading:
+		return statusDownload
+	case session.StateUploading:
+		return statusSeed
+	case session.StateQueued:
+		if t.st.Done {
+			return statusSeedWait
+		}
+		return statusDownloadWait
+	}
+	return statusStopped
+}
+
+type file struct {
+	Name           string `json:"name"`
+	Length         int    `json:"length"`
+	BytesCompleted int    `json:"bytesCompleted"`
+}
+
+type fileStat struct {
+	BytesCompleted int  `json:"bytesCompleted"`
+	Wanted         bool `json:"wanted"`
+	Priority       int  `json:"priority"`
+}
+
+type tracker struct {
+	ID       int    `json:"id"`
+	Announce string `json:"announce"`
+	Tier     int    `json:"tier"`
+}
+
+// priority converts a priority to the -1, 0 or 1 of Transmission.
+func priority(p torrentmeta.Priority) int {
+	switch p {
+	case torrentmeta.PriorityLow:
+		return -1
+	case torrentmeta.PriorityHigh:
+		return 1
+	}
+	return 0
+}
+
+// fields computes the values of torrent-get.
+var fields = map[string]func(t *torrent) interface{}{
+	"id":         func(t *torrent) interface{} { return t.id },
+	"hashString": func(t *torrent) interface{} { return hex.EncodeToString(t.st.InfoHash[:]) },
+	"name":       func(t *torrent) interface{} { return t.st.Name },
+	"status":     func(t *torrent) interface{} { return t.status() },
+	"error": func(t *torrent) interface{} {
+		if t.st.Error != "" {
+			return errorLocal
+		}
+		return 0
+	},
+	"errorString":             func(t *torrent) interface{} { return t.st.Error },
+	"percentDone":             func(t *torrent) interface{} { return t.st.Progress },
+	"recheckProgress":         func(t *torrent) interface{} { return t.st.Checked },
+	"metadataPercentComplete": func(t *torrent) interface{} { return 1 },
+	"totalSize":               func(t *torrent) interface{} { return t.st.Size },
+	"sizeWhenDone":            func(t *torrent) interface{} { return t.st.Wanted },
+	"leftUntilDone":           func(t *torrent) interface{} { return t.st.Left },
+	"haveValid":               func(t *torrent) interface{} { return t.st.Completed },
+	"haveUnchecked":           func(t *torrent) interface{} { return 0 },
+	"downloadedEver":          func(t *torrent) interface{} { return t.st.Downloaded },
+	"uploadedEver":            func(t *torrent) interface{} { return t.st.Uploaded },
+	"uploadRatio":             func(t *torrent) interface{} { return t.st.Ratio },
+	"rateDownload":            func(t *torrent) interface{} { return t.st.DownloadRate },
+	"rateUpload":              func(t *torrent) interface{} { return t.st.UploadRate },
+	"eta":                     func(t *torrent) interface{} { return t.eta() },
+	"isFinished":              func(t *torrent) interface{} { return t.st.Done && !t.st.Running },
+	"isStalled":               func(t *torrent) interface{} { return false },
+	"isPrivate":               func(t *torrent) interface{} { return false },
+	"downloadDir":             func(t *torrent) interface{} { return t.st.SavePath },
+	"addedDate":               func(t *torrent) interface{} { return t.st.AddedAt.Unix() },
+	"doneDate":                func(t *torrent) interface{} { return 0 },
+	"activityDate":            func(t *torrent) interface{} { return 0 },
+	"queuePosition":           func(t *torrent) interface{} { return t.st.QueuePosition },
+	"comment":                 func(t *torrent) interface{} { return t.st.Comment },
+	"creator":                 func(t *torrent) interface{} { return t.st.CreatedBy },
+	"pieceCount":              func(t *torrent) interface{} { return t.st.Pieces },
+	"pieceSize":               func(t *torrent) interface{} { return t.st.PieceLength },
+	"peersConnected":          func(t *torrent) interface{} { return t.st.Peers },
+	"peersGettingFromUs":      func(t *torrent) interface{} { return 0 },
+	"peersSendingToUs":        func(t *torrent) interface{} { return 0 },
+	"downloadLimit":           func(t *torrent) interface{} { return t.st.DownloadLimit / speedUnit },
+	"downloadLimited":         func(t *torrent) interface{} { return t.st.DownloadLimit > 0 },
+	"uploadLimit":             func(t *torrent) interface{} { return t.st.UploadLimit / speedUnit },
+	"uploadLimited":           func(t *torrent) interface{} { return t.st.UploadLimit > 0 },
+	"seedRatioLimit":          func(t *torrent) interface{} { return t.st.SeedGoal.Limits.Ratio },
+	"seedRatioMode":           func(t *torrent) interface{} { return seedMode(t.st.SeedLimits.Ratio) },
+	"seedIdleLimit":           func(t *torrent) interface{} { return t.st.SeedGoal.Limits.IdleTime },
+	"seedIdleMode":            func(t *torrent) interface{} { return seedMode(t.st.SeedLimits.IdleTime) },
+	"secondsSeeding":          func(t *torrent) interface{} { return int(t.st.SeedTime / time.Second) },
+	"labels": func(t *torrent) interface{} {
+		if t.st.Label == "" {
+			return []string{}
+		}
+		return []string{t.st.Label}
+	},
+	"magnetLink": func(t *torrent) interface{} {
+		return fmt.Sprintf("magnet:?xt=urn:btih:%s", hex.EncodeToString(t.st.InfoHash[:]))
+	},
+	"trackers": func(t *torrent) interface{} {
+		return []tracker{{Announce: t.st.Announce}}
+	},
+	"files": func(t *torrent) interface{} {
+		files := make([]file, len(t.st.Files))
+		for i, f := range t.st.Files {
+			files[i] = file{
+				Name:           filepath.ToSlash(f.Path),
+				Length:         f.Length,
+				BytesCompleted: f.Completed,
+			}
+		}
+		return files
+	},
+	"fileStats": func(t *torrent) interface{} {
+		stats := make([]fileStat, len(t.st.Files))
+		for i, f := range t.st.Files {
+			stats[i] = fileStat{
+				BytesCompleted: f.Completed,
+				Wanted:         f.Priority != torrentmeta.PrioritySkip,
+				Priority:       priority(f.Priority),
+			}
+		}
+		return stats
+	},
+	"wanted": func(t *torrent) interface{} {
+		wanted := make([]int, len(t.st.Files))
+		for i, f := range t.st.Files {
+			if f.Priority != torrentmeta.PrioritySkip {
+				wanted[i] = 1
+			}
+		}
+		return wanted
+	},
+	"priorities": func(t *torrent) interface{} {
+		prios := make([]int, len(t.st.Files))
+		for i, f := range t.st.Files {
+			prios[i] = priority(f.Priority)
+		}
+		return prios
+	},
+}
+
+func (srv *Server) torrentGet(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		IDs    selector `json:"ids"`
+		Fields []string `json:"fields"`
+	}
+	if err := decode(raw, &args); err != nil {
+		return nil, err
+	}
+	if len(args.Fields) == 0 {
+		return nil, errors.New("no fields given")
+	}
+	torrents := []map[string]interface{}{}
+	list := srv.s.List()
+	removed := srv.forget(list)
+	for pos, st := range list {
+		id := srv.id(st.InfoHash)
+		if !srv.matches(args.IDs, id, st) {
+			continue
+		}
+		d, err := srv.s.Details(st.InfoHash)
+		if err != nil {
+			continue
+		}
+		t := &torrent{id: id, pos: pos, st: d}
+		values := make(map[string]interface{}, len(args.Fields))
+		for _, name := range args.Fields {
+			if field, ok := fields[name]; ok {
+				values[name] = field(t)
+			}
+		}
+		torrents = append(torrents, values)
+	}
+	reply := map[string]interface{}{"torrents": torrents}
+	if args.IDs.recent {
+		reply["removed"] = removed
+	}
+	return reply, nil
+}
+
+type setArgs struct {
+	IDs             selector `json:"ids"`
+	FilesWanted     []int    `json:"files-wanted"`
+	FilesUnwanted   []int    `json:"files-unwanted"`
+	PriorityHigh    []int    `json:"priority-high"`
+	PriorityNormal  []int    `json:"priority-normal"`
+	PriorityLow     []int    `json:"priority-low"`
+	DownloadLimit   *int     `json:"downloadLimit"`
+	DownloadLimited *bool    `json:"downloadLimited"`
+	UploadLimit     *int     `json:"uploadLimit"`
+	UploadLimited   *bool    `json:"uploadLimited"`
+	SeedRatioLimit  *float64 `json:"seedRatioLimit"`
+	SeedRatioMode   *int     `json:"seedRatioMode"`
+	SeedIdleLimit   *int     `json:"seedIdleLimit"`
+	SeedIdleMode    *int     `json:"seedIdleMode"`
+	Labels          []string `json:"labels"`
+}
+
+// limit applies the limit and limited arguments of torrent-set to a limit
+// in bytes per second.
+func limit(current int, kb *int, limited *bool) int {
+	if kb != nil {
+		current = *kb * speedUnit
+	}
+	if limited != nil && !*limited {
+		current = 0
+	}
+	return current
+}
+
+// filePriority returns the priority of a file of a torrent. The session
+// rejects the files it does not have.
+func filePriority(st session.Details, i int) torrentmeta.Priority {
+	if i < 0 || i >= len(st.Files) {
+		return torrentmeta.PriorityNormal
+	}
+	return st.Files[i].Priority
+}
+
+// Modes of the seeding limits of a torrent.
+const (
+	seedModeGlobal = iota
+	seedModeSingle
+	seedModeUnlimited
+)
+
+func seedMode[T int | float64](limit T) int {
+	switch {
+	case limit > 0:
+		return seedModeSingle
+	case limit < 0:
+		return seedModeUnlimited
+	}
+	return seedModeGlobal
+}
+
+// seedLimit applies the limit and mode arguments of torrent-set to a
+// seeding limit of a torrent. A limit given in another mode than single is
+// not kept.
+func seedLimit[T int | float64](current T, value *T, mode *int) T {
+	m := seedMode(current)
+	if mode != nil {
+		m = *mode
+	}
+	switch m {
+	case seedModeGlobal:
+		return 0
+	case seedModeUnlimited:
+		return -1
+	}
+	if value != nil {
+		return max(*value, 0)
+	}
+	return max(current, 0)
+}
+
+func (srv *Server) torrentSet(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args setArgs
+	if err := decode(raw, &args); err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, hash := range srv.selected(args.IDs) {
+		st, err := srv.s.Details(hash)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		prios := make(map[int]torrentmeta.Priority)
+		for _, i := range args.FilesWanted {
+			if filePriority(st, i) == torrentmeta.PrioritySkip {
+				prios[i] = torrentmeta.PriorityNormal
+			}
+		}
+		for _, i := range args.FilesUnwanted {
+			prios[i] = torrentmeta.PrioritySkip
+		}
+		for p, files := range map[torrentmeta.Priority][]int{
+			torrentmeta.PriorityHigh:   args.PriorityHigh,
+			torrentmeta.PriorityNormal: args.PriorityNormal,
+			torrentmeta.PriorityLow:    args.PriorityLow,
+		} {
+			for _, i := range files {
+				cur, ok := prios[i]
+				if !ok {
+					cur = filePriority(st, i)
+				}
+				if cur != torrentmeta.PrioritySkip {
+					prios[i] = p
+				}
+			}
+		}
+		if len(prios) > 0 {
+			errs = append(errs, srv.s.SetFilePriorities(hash, prios))
+		}
+		if args.DownloadLimit != nil || args.DownloadLimited != nil || args.UploadLimit != nil || args.UploadLimited != nil {
+			down := limit(st.DownloadLimit, args.DownloadLimit, args.DownloadLimited)
+			up := limit(st.UploadLimit, args.UploadLimit, args.UploadLimited)
+			errs = append(errs, srv.s.SetTorrentLimits(hash, down, up))
+		}
+		if args.SeedRatioLimit != nil || args.SeedRatioMode != nil || args.SeedIdleLimit != nil || args.SeedIdleMode != nil {
+			limits := st.SeedLimits
+			limits.Ratio = seedLimit(limits.Ratio, args.SeedRatioLimit, args.SeedRatioMode)
+			limits.IdleTime = seedLimit(limits.IdleTime, args.SeedIdleLimit, args.SeedIdleMode)
+			errs = append(errs, srv.s.SetTorrentSeedLimits(hash, limits))
+		}
+		if args.Labels != nil {
+			// A torrent is in one category at most.
+			label := ""
+			if len(args.Labels) > 0 {
+				label = args.Labels[0]
+			}
+			errs = append(errs, srv.s.SetLabel(hash, label))
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (srv *Server) sessionGet(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	cfg := srv.s.Config()
+	down, up := srv.s.Limits()
+	seeding := srv.s.SeedLimits()
+	queue := srv.s.Queue()
+	return map[string]interface{}{
+		"rpc-version":                rpcVersion,
+		"rpc-version-minimum":        rpcVersionMinimum,
+		"version":                    version,
+		"session-id":                 srv.sessionID,
+		"config-dir":                 cfg.ConfigPath,
+		"download-dir":               cfg.DownloadPath,
+		"incomplete-dir":             cfg.IncompletePath,
+		"incomplete-dir-enabled":     cfg.IncompletePath != "",
+		"peer-port":                  cfg.ListenPort,
+		"speed-limit-down":           down / speedUnit,
+		"speed-limit-down-enabled":   down > 0,
+		"speed-limit-up":             up / speedUnit,
+		"speed-limit-up-enabled":     up > 0,
+		"seedRatioLimit":             seeding.Ratio,
+		"seedRatioLimited":           seeding.Ratio > 0,
+		"idle-seeding-limit":         seeding.IdleTime,
+		"idle-seeding-limit-enabled": seeding.IdleTime > 0,
+		"download-queue-size":        queue.MaxDownloads,
+		"download-queue-enabled":     queue.MaxDownloads > 0,
+		"seed-queue-size":            queue.MaxSeeds,
+		"seed-queue-enabled":         queue.MaxSeeds > 0,
+		"units": map[string]interface{}{
+			"speed-units":  []string{"kB/s", "MB/s", "GB/s", "TB/s"},
+			"speed-bytes":  speedUnit,
+			"size-units":   []string{"kB", "MB", "GB", "TB"},
+			"size-bytes":   1000,
+			"memory-units": []string{"KiB", "MiB", "GiB", "TiB"},
+			"memory-bytes": 1024,
+		},
+	}, nil
+}
+
+type stats struct {
+	UploadedBytes   int64 `json:"uploadedBytes"`
+	DownloadedBytes int64 `json:"downloadedBytes"`
+	FilesAdded      int   `json:"filesAdded"`
+	SessionCount    int   `json:"sessionCount"`
+	SecondsActive   int   `json:"secondsActive"`
+}
+
+func (srv *Server) sessionStats(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	active, paused := 0, 0
+	list := srv.s.List()
+	for _, st := range list {
+		if st.State == session.StateDownloading || st.State == session.StateUploading {
+			active++
+		} else {
+			paused++
+		}
+	}
+	totals := srv.s.Totals()
+	current := stats{
+		DownloadedBytes: totals.Session.Downloaded,
+		UploadedBytes:   totals.Session.Uploaded,
+		SessionCount:    1,
+		SecondsActive:   int(time.Since(totals.StartedAt).Seconds()),
+	}
+	cumulative := current
+	cumulative.DownloadedBytes = totals.AllTime.Downloaded
+	cumulative.UploadedBytes = totals.AllTime.Uploaded
+	return map[string]interface{}{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       len(list),
+		"downloadSpeed":      totals.DownloadRate,
+		"uploadSpeed":        totals.UploadRate,
+		"current-stats":      current,
+		"cumulative-stats":   cumulative,
+	}, nil
+}
@@ -0,0 +1,476 @@
+// Package transmission serves the core of the Transmission RPC protocol, so
+// tools made for Transmission can control a session.
+package transmission
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/magnet"
+	"github.com/DanArmor/GoTorrent/pkg/session"
+)
+
+// Path is where Transmission clients send their requests.
+const Path = "/transmission/rpc"
+
+const SessionIDHeader = "X-Transmission-Session-Id"
+
+const (
+	rpcVersion        = 17
+	rpcVersionMinimum = 14
+	version           = "4.0.0 (GoTorrent)"
+)
+
+// MagnetTimeout bounds the metadata fetch of added magnet links.
+const MagnetTimeout = 5 * time.Minute
+
+// maxRequestSize bounds the body of a request, which may carry a torrent
+// file.
+const maxRequestSize = 32 << 20
+
+type request struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+type response struct {
+	Result    string          `json:"result"`
+	Arguments interface{}     `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+type handler func(ctx context.Context, args json.RawMessage) (interface{}, error)
+
+// Server implements the Transmission RPC methods over a session. Torrents
+// get numeric ids in the order they are first seen, which stay stable for
+// the life of the server.
+type Server struct {
+	s         *session.Session
+	sessionID string
+	methods   map[string]handler
+	fetch     *http.Client
+	mu        sync.Mutex
+	ids       map[session.InfoHash]int
+	nextID    int
+	// listed holds the torrents with an id that were seen in the session.
+	// Magnet links get their id before their torrent is added.
+	listed map[session.InfoHash]bool
+	// removed holds when the ids of the torrents that are gone were
+	// dropped, for the replies to "recently-active".
+	removed map[int]time.Time
+	// changed holds the last state seen of the torrents and since when.
+	// A torrent seen for the first time counts as changed.
+	changed map[session.InfoHash]change
+}
+
+type change struct {
+	state session.State
+	at    time.Time
+}
+
+// recentAge is how long torrents that changed state and ids of removed
+// torrents are reported to "recently-active".
+const recentAge = time.Minute
+
+func NewServer(s *session.Session) *Server {
+	id := make([]byte, 24)
+	rand.Read(id)
+	srv := &Server{
+		s:         s,
+		sessionID: hex.EncodeToString(id),
+		fetch:     &http.Client{Timeout: 30 * time.Second},
+		ids:       make(map[session.InfoHash]int),
+		nextID:    1,
+		listed:    make(map[session.InfoHash]bool),
+		removed:   make(map[int]time.Time),
+		changed:   make(map[session.InfoHash]change),
+	}
+	srv.methods = map[string]handler{
+		"torrent-add":       srv.torrentAdd,
+		"torrent-get":       srv.torrentGet,
+		"torrent-start":     srv.torrentStart,
+		"torrent-stop":      srv.torrentStop,
+		"torrent-remove":    srv.torrentRemove,
+		"torrent-set":       srv.torrentSet,
+		"session-get":       srv.sessionGet,
+		"session-stats":     srv.sessionStats,
+		"queue-move-top":    srv.queueMove(session.QueueTop),
+		"queue-move-up":     srv.queueMove(session.QueueUp),
+		"queue-move-down":   srv.queueMove(session.QueueDown),
+		"queue-move-bottom": srv.queueMove(session.QueueBottom),
+	}
+	return srv
+}
+
+// ServeHTTP answers requests without the current session id with 409 and
+// the id, as Transmission does against CSRF.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(SessionIDHeader, srv.sessionID)
+	if r.Header.Get(SessionIDHeader) != srv.sessionID {
+		http.Error(w, fmt.Sprintf("<h1>409: Conflict</h1><p>Your request had an invalid session-id header.</p><p><code>%s: %s</code></p>", SessionIDHeader, srv.sessionID), http.StatusConflict)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := response{Result: "success", Arguments: struct{}{}, Tag: req.Tag}
+	if m, ok := srv.methods[req.Method]; !ok {
+		resp.Result = "method name not recognized"
+	} else if args, err := m(r.Context(), req.Arguments); err != nil {
+		resp.Result = err.Error()
+	} else if args != nil {
+		resp.Arguments = args
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func decode(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// id returns the id of a torrent of the session, giving it one when it has
+// none.
+func (srv *Server) id(hash session.InfoHash) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.listed[hash] = true
+	return srv.idLocked(hash)
+}
+
+// pendingID returns the id of a magnet link whose torrent is not added yet.
+func (srv *Server) pendingID(hash session.InfoHash) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.idLocked(hash)
+}
+
+func (srv *Server) idLocked(hash session.InfoHash) int {
+	id, ok := srv.ids[hash]
+	if !ok {
+		id = srv.nextID
+		srv.nextID++
+		srv.ids[hash] = id
+	}
+	return id
+}
+
+// forget drops the ids of the torrents that left the session, however they
+// were removed, given the torrents it has now. It returns the ids dropped
+// lately.
+func (srv *Server) forget(torrents []session.Status) []int {
+	kept := make(map[session.InfoHash]bool, len(torrents))
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, st := range torrents {
+		kept[st.InfoHash] = true
+		if _, ok := srv.ids[st.InfoHash]; ok {
+			srv.listed[st.InfoHash] = true
+		}
+	}
+	for hash := range srv.changed {
+		if !kept[hash] {
+			delete(srv.changed, hash)
+		}
+	}
+	now := time.Now()
+	for hash, id := range srv.ids {
+		if !srv.listed[hash] || kept[hash] {
+			continue
+		}
+		// The torrent may have been added since torrents was read.
+		if _, err := srv.s.Torrent(hash); err != nil {
+			delete(srv.ids, hash)
+			delete(srv.listed, hash)
+			srv.removed[id] = now
+		}
+	}
+	removed := []int{}
+	for id, at := range srv.removed {
+		if now.Sub(at) > recentAge {
+			delete(srv.removed, id)
+		} else {
+			removed = append(removed, id)
+		}
+	}
+	slices.Sort(removed)
+	return removed
+}
+
+// selector is the ids argument: absent for all torrents, a number, a hash,
+// a list of both, or "recently-active" for the torrents that move data,
+// check or move their files, or changed state in the last minute.
+type selector struct {
+	recent  bool
+	ids     map[int]bool
+	hashes  map[string]bool
+	present bool
+}
+
+func (sel *selector) UnmarshalJSON(buf []byte) error {
+	sel.present = true
+	sel.ids = make(map[int]bool)
+	sel.hashes = make(map[string]bool)
+	var one interface{}
+	if err := json.Unmarshal(buf, &one); err != nil {
+		return err
+	}
+	values, ok := one.([]interface{})
+	if !ok {
+		values = []interface{}{one}
+	}
+	for _, v := range values {
+		switch v := v.(type) {
+		case float64:
+			sel.ids[int(v)] = true
+		case string:
+			if v == "recently-active" {
+				sel.recent = true
+			} else {
+				sel.hashes[strings.ToLower(v)] = true
+			}
+		default:
+			return fmt.Errorf("invalid id %v", v)
+		}
+	}
+	return nil
+}
+
+func (srv *Server) matches(sel selector, id int, st session.Status) bool {
+	if !sel.present || sel.recent && srv.active(st) {
+		return true
+	}
+	return sel.ids[id] || sel.hashes[hex.EncodeToString(st.InfoHash[:])]
+}
+
+// active tells whether a torrent is recently active, recording when it
+// changed state.
+func (srv *Server) active(st session.Status) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	now := time.Now()
+	c, ok := srv.changed[st.InfoHash]
+	if !ok || c.state != st.State {
+		c = change{state: st.State, at: now}
+		srv.changed[st.InfoHash] = c
+	}
+	switch {
+	case st.DownloadRate > 0 || st.UploadRate > 0:
+		return true
+	case st.State == session.StateDownloading || st.State == session.StateUploading,
+		st.State == session.StateChecking || st.State == session.StateMoving:
+		return true
+	}
+	return now.Sub(c.at) <= recentAge
+}
+
+// selected returns the hashes of the torrents chosen by sel, in the order of
+// the session.
+func (srv *Server) selected(sel selector) []session.InfoHash {
+	var hashes []session.InfoHash
+	for _, st := range srv.s.List() {
+		if srv.matches(sel, srv.id(st.InfoHash), st) {
+			hashes = append(hashes, st.InfoHash)
+		}
+	}
+	return hashes
+}
+
+type idsArgs struct {
+	IDs selector `json:"ids"`
+}
+
+type addArgs struct {
+	Filename    string `json:"filename"`
+	Metainfo    string `json:"metainfo"`
+	DownloadDir string `json:"download-dir"`
+	Paused      bool   `json:"paused"`
+}
+
+type addedTorrent struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	HashString string `json:"hashString"`
+}
+
+func (srv *Server) torrentAdd(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args addArgs
+	if err := decode(raw, &args); err != nil {
+		return nil, err
+	}
+	opts := session.AddOptions{SavePath: args.DownloadDir, Start: !args.Paused}
+	var metainfo []byte
+	switch {
+	case args.Metainfo != "":
+		buf, err := base64.StdEncoding.DecodeString(args.Metainfo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metainfo: %w", err)
+		}
+		metainfo = buf
+	case strings.HasPrefix(args.Filename, "magnet:"):
+		return srv.addMagnet(args.Filename, opts)
+	case strings.HasPrefix(args.Filename, "http://") || strings.HasPrefix(args.Filename, "https://"):
+		buf, err := srv.download(ctx, args.Filename)
+		if err != nil {
+			return nil, err
+		}
+		metainfo = buf
+	case args.Filename != "":
+		hash, err := srv.s.AddTorrent(args.Filename, opts)
+		return srv.added(hash, err)
+	default:
+		return nil, errors.New("no filename or metainfo given")
+	}
+	hash, err := srv.s.AddTorrentData(metainfo, opts)
+	return srv.added(hash, err)
+}
+
+// added builds the reply of torrent-add.
+func (srv *Server) added(hash session.InfoHash, err error) (interface{}, error) {
+	key := "torrent-added"
+	if errors.Is(err, session.ErrExists) {
+		key = "torrent-duplicate"
+	} else if err != nil {
+		return nil, err
+	}
+	st, err := srv.s.Status(hash)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]addedTorrent{key: {
+		ID:         srv.id(hash),
+		Name:       st.Name,
+		HashString: hex.EncodeToString(hash[:]),
+	}}, nil
+}
+
+// addMagnet replies at once, fetching the metadata in the background.
+func (srv *Server) addMagnet(uri string, opts session.AddOptions) (interface{}, error) {
+	link, err := magnet.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := srv.s.Status(link.InfoHash); err == nil {
+		return srv.added(link.InfoHash, session.ErrExists)
+	}
+	srv.s.Go(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, MagnetTimeout)
+		defer cancel()
+		_, err := srv.s.AddMagnet(ctx, uri, opts)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			srv.s.Logger().Error("can't add magnet link", "err", err)
+		}
+	})
+	name := link.Name
+	if name == "" {
+		name = hex.EncodeToString(link.InfoHash[:])
+	}
+	return map[string]addedTorrent{"torrent-added": {
+		ID:         srv.pendingID(link.InfoHash),
+		Name:       name,
+		HashString: hex.EncodeToString(link.InfoHash[:]),
+	}}, nil
+}
+
+func (srv *Server) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := srv.fetch.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxRequestSize))
+}
+
+func (srv *Server) torrentStart(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args idsArgs
+	if err := decode(raw, &args); err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, hash := range srv.selected(args.IDs) {
+		errs = append(errs, srv.s.Resume(hash))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (srv *Server) torrentStop(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args idsArgs
+	if err := decode(raw, &args); err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, hash := range srv.selected(args.IDs) {
+		errs = append(errs, srv.s.Pause(hash))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (srv *Server) queueMove(move session.QueueMove) handler {
+	return func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+		var args idsArgs
+		if err := decode(raw, &args); err != nil {
+			return nil, err
+		}
+		hashes := srv.selected(args.IDs)
+		// The torrents are moved in the order that keeps their own order.
+		if move == session.QueueDown || move == session.QueueTop {
+			slices.Reverse(hashes)
+		}
+		var errs []error
+		for _, hash := range hashes {
+			errs = append(errs, srv.s.MoveQueue(hash, move))
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+func (srv *Server) torrentRemove(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		IDs             selector `json:"ids"`
+		DeleteLocalData bool     `json:"delete-local-data"`
+	}
+	if err := decode(raw, &args); err != nil {
+		return nil, err
+	}
+	if !args.IDs.present {
+		return nil, errors.New("no torrents given")
+	}
+	var errs []error
+	for _, hash := range srv.selected(args.IDs) {
+		errs = append(errs, srv.s.Remove(hash, args.DeleteLocalData))
+	}
+	srv.forget(srv.s.List())
+	return nil, errors.Join(errs...)
+}
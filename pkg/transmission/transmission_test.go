@@ -0,0 +1,49 @@
+package transmission
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DanArmor/GoTorrent/pkg/session"
+)
+
+func TestRecentlyActive(t *testing.T) {
+	srv := NewServer(nil)
+	var sel selector
+	if err := json.Unmarshal([]byte(`"recently-active"`), &sel); err != nil {
+		t.Fatal(err)
+	}
+	stopped := session.Status{InfoHash: session.InfoHash{1}, State: session.StateStopped}
+	running := session.Status{InfoHash: session.InfoHash{2}, State: session.StateDownloading}
+	if !srv.matches(sel, 1, stopped) || !srv.matches(sel, 2, running) {
+		t.Fatal("torrents seen for the first time are not active")
+	}
+
+	// A minute later, only the torrent moving data is active.
+	for hash, c := range srv.changed {
+		c.at = c.at.Add(-2 * recentAge)
+		srv.changed[hash] = c
+	}
+	if srv.matches(sel, 1, stopped) {
+		t.Error("torrent stopped for long is active")
+	}
+	if !srv.matches(sel, 2, running) {
+		t.Error("downloading torrent is not active")
+	}
+	seeded := stopped
+	seeded.UploadRate = 1
+	if !srv.matches(sel, 1, seeded) {
+		t.Error("torrent uploading is not active")
+	}
+
+	// A change of state makes it active again.
+	paused := running
+	paused.State = session.StateStopped
+	if !srv.matches(sel, 2, paused) {
+		t.Error("torrent stopped lately is not active")
+	}
+	if c := srv.changed[paused.InfoHash]; time.Since(c.at) > time.Second {
+		t.Errorf("change of state recorded at %v", c.at)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// notify sends the names of the files closed after writing or moved into
+// dir, and an empty name when events were lost. The channel is closed when
+// ctx is done or inotify fails.
+func notify(ctx context.Context, dir string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// A non-blocking descriptor is read through the runtime poller, so
+	// closing the file stops a pending read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	names := make(chan string)
+	go func() {
+		defer close(names)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+				if ev.Mask&syscall.IN_IGNORED != 0 {
+					// The directory is gone.
+					return
+				}
+				if ev.Mask&syscall.IN_Q_OVERFLOW == 0 && len(name) == 0 {
+					continue
+				}
+				select {
+				case names <- string(bytes.TrimRight(name, "\x00")):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return names, nil
+}
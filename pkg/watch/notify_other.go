@@ -0,0 +1,12 @@
+//go:build !linux
+
+package watch
+
+import (
+	"context"
+	"errors"
+)
+
+func notify(ctx context.Context, dir string) (<-chan string, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,95 @@
+// Package watch reports the files that appear in directories.
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Dir calls fn with the path of every regular file in dir when it starts
+// and then of each file written or moved into dir, until ctx is done. It
+// uses inotify where it is available and otherwise rescans dir every
+// interval, reporting files once they stopped changing. When polling, fn is
+// called again for the files it leaves in dir.
+func Dir(ctx context.Context, dir string, interval time.Duration, fn func(path string)) error {
+	events, err := notify(ctx, dir)
+	if err != nil {
+		return poll(ctx, dir, interval, fn)
+	}
+	if err := scan(dir, fn); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case name, ok := <-events:
+			if !ok {
+				// inotify failed, go on polling.
+				return poll(ctx, dir, interval, fn)
+			}
+			if name == "" {
+				// Events were lost.
+				scan(dir, fn)
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+				fn(path)
+			}
+		}
+	}
+}
+
+func scan(dir string, fn func(path string)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			fn(filepath.Join(dir, e.Name()))
+		}
+	}
+	return nil
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func poll(ctx context.Context, dir string, interval time.Duration, fn func(path string)) error {
+	if err := scan(dir, fn); err != nil {
+		return err
+	}
+	seen := make(map[string]fileState)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		next := make(map[string]fileState, len(entries))
+		for _, e := range entries {
+			fi, err := e.Info()
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			st := fileState{size: fi.Size(), modTime: fi.ModTime()}
+			next[e.Name()] = st
+			if prev, ok := seen[e.Name()]; ok && prev == st {
+				fn(filepath.Join(dir, e.Name()))
+			}
+		}
+		seen = next
+	}
+}